@@ -0,0 +1,89 @@
+package loadgen
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"time"
+)
+
+const (
+	ConstantArrivals = "constant"
+	PoissonArrivals  = "poisson"
+	BurstyArrivals   = "bursty"
+)
+
+// Arrivals generates the gaps between consecutive request start times
+type Arrivals interface {
+	Next() time.Duration
+}
+
+func rateToInterval(rate float64) time.Duration {
+	return time.Duration(float64(time.Second) / rate)
+}
+
+// Constant starts requests at evenly spaced intervals
+type Constant struct {
+	interval time.Duration
+}
+
+func NewConstant(rate float64) *Constant {
+	return &Constant{interval: rateToInterval(rate)}
+}
+
+func (c *Constant) Next() time.Duration {
+	return c.interval
+}
+
+// Poisson starts requests with exponentially distributed gaps
+type Poisson struct {
+	rate float64
+	r    *rand.Rand
+}
+
+func NewPoisson(rate float64, r *rand.Rand) *Poisson {
+	return &Poisson{rate: rate, r: r}
+}
+
+func (p *Poisson) Next() time.Duration {
+	return time.Duration(p.r.ExpFloat64() / p.rate * float64(time.Second))
+}
+
+// Bursty starts requests in bursts of burstSize simultaneous arrivals.
+// Bursts are spaced so that the average rate stays at rate.
+type Bursty struct {
+	burstSize int
+	interval  time.Duration
+	inBurst   int
+}
+
+func NewBursty(rate float64, burstSize int) *Bursty {
+	burstSize = max(burstSize, 1)
+	return &Bursty{
+		burstSize: burstSize,
+		interval:  rateToInterval(rate / float64(burstSize)),
+	}
+}
+
+func (b *Bursty) Next() time.Duration {
+	b.inBurst++
+	if b.inBurst < b.burstSize {
+		return 0
+	}
+	b.inBurst = 0
+	return b.interval
+}
+
+func NewArrivals(kind string, rate float64, burstSize int, r *rand.Rand) (Arrivals, error) {
+	if rate <= 0 {
+		return nil, fmt.Errorf("arrival rate must be positive, got %v", rate)
+	}
+	switch kind {
+	case ConstantArrivals:
+		return NewConstant(rate), nil
+	case PoissonArrivals:
+		return NewPoisson(rate, r), nil
+	case BurstyArrivals:
+		return NewBursty(rate, burstSize), nil
+	}
+	return nil, fmt.Errorf("unknown arrival process %q", kind)
+}
@@ -0,0 +1,109 @@
+package loadgen
+
+import (
+	"context"
+	"github.com/Volume999/BroadleafSimulation/metrics"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// OpenLoop starts requests at the times given by the arrival process, regardless of
+// how many earlier requests are still in flight. Unlike a closed loop, the offered load
+// does not drop when the system saturates, so queueing delay shows up in the latency.
+type OpenLoop struct {
+	arrivals Arrivals
+	duration time.Duration
+	// maxInFlight bounds the number of outstanding requests, arrivals beyond it are dropped.
+	// Zero means no bound.
+	maxInFlight int
+}
+
+type Result struct {
+	Scheduled   int
+	Completed   int
+	Dropped     int
+	MaxInFlight int
+	Elapsed     time.Duration
+	// Failed is the number of requests that returned an error, they are not counted as Completed
+	Failed int
+	// Latency is measured from the scheduled start time of a completed request
+	Latency *metrics.Histogram
+	// FailedLatency is the latency of the failed requests
+	FailedLatency *metrics.Histogram
+	// ServiceTime is measured from the actual start time of a completed request
+	ServiceTime *metrics.Histogram
+	// StartDelay is the lag between the scheduled and the actual start time
+	StartDelay *metrics.Histogram
+}
+
+func NewOpenLoop(arrivals Arrivals, duration time.Duration, maxInFlight int) *OpenLoop {
+	return &OpenLoop{
+		arrivals:    arrivals,
+		duration:    duration,
+		maxInFlight: maxInFlight,
+	}
+}
+
+// Run schedules requests for the configured duration and waits for all of them to finish.
+// Scheduling stops early if ctx is cancelled. execute is passed the sequence number of the request,
+// a request that returns an error is counted as failed.
+func (o *OpenLoop) Run(ctx context.Context, execute func(i int) error) *Result {
+	res := &Result{
+		Latency:       metrics.NewHistogram(),
+		FailedLatency: metrics.NewHistogram(),
+		ServiceTime:   metrics.NewHistogram(),
+		StartDelay:    metrics.NewHistogram(),
+	}
+	var inFlight, maxInFlight atomic.Int64
+	wg := &sync.WaitGroup{}
+	timer := time.NewTimer(time.Hour)
+	timer.Stop()
+	defer timer.Stop()
+	start := time.Now()
+	end := start.Add(o.duration)
+	scheduled := start
+schedule:
+	for scheduled.Before(end) {
+		if wait := time.Until(scheduled); wait > 0 {
+			timer.Reset(wait)
+			select {
+			case <-ctx.Done():
+				break schedule
+			case <-timer.C:
+			}
+		} else if ctx.Err() != nil {
+			break
+		}
+		res.Scheduled++
+		current := inFlight.Add(1)
+		if o.maxInFlight > 0 && current > int64(o.maxInFlight) {
+			inFlight.Add(-1)
+			res.Dropped++
+		} else {
+			for m := maxInFlight.Load(); current > m && !maxInFlight.CompareAndSwap(m, current); m = maxInFlight.Load() {
+			}
+			wg.Add(1)
+			go func(i int, scheduled time.Time) {
+				defer wg.Done()
+				defer inFlight.Add(-1)
+				actual := time.Now()
+				res.StartDelay.Record(actual.Sub(scheduled))
+				err := execute(i)
+				now := time.Now()
+				if err != nil {
+					res.FailedLatency.Record(now.Sub(scheduled))
+					return
+				}
+				res.ServiceTime.Record(now.Sub(actual))
+				res.Latency.Record(now.Sub(scheduled))
+			}(res.Scheduled-1, scheduled)
+		}
+		scheduled = scheduled.Add(o.arrivals.Next())
+	}
+	wg.Wait()
+	res.Elapsed = time.Since(start)
+	res.Completed, res.Failed = int(res.Latency.Count()), int(res.FailedLatency.Count())
+	res.MaxInFlight = int(maxInFlight.Load())
+	return res
+}
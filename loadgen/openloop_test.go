@@ -0,0 +1,65 @@
+package loadgen
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"testing"
+	"time"
+)
+
+func TestOpenLoopKeepsOfferedLoad(t *testing.T) {
+	// Each request takes 20ms, a closed loop with one worker would start only ~10 of them
+	o := NewOpenLoop(NewConstant(200), 200*time.Millisecond, 0)
+	res := o.Run(context.Background(), func(int) error {
+		time.Sleep(20 * time.Millisecond)
+		return nil
+	})
+	if res.Scheduled < 35 || res.Completed != res.Scheduled || res.Dropped != 0 {
+		t.Fatalf("unexpected counts: scheduled=%d completed=%d dropped=%d", res.Scheduled, res.Completed, res.Dropped)
+	}
+	if res.MaxInFlight < 2 {
+		t.Errorf("expected overlapping requests, max in flight %d", res.MaxInFlight)
+	}
+}
+
+func TestOpenLoopDropsBeyondMaxInFlight(t *testing.T) {
+	o := NewOpenLoop(NewBursty(1000, 10), 50*time.Millisecond, 5)
+	res := o.Run(context.Background(), func(int) error {
+		time.Sleep(5 * time.Millisecond)
+		return nil
+	})
+	if res.Dropped == 0 || res.MaxInFlight > 5 {
+		t.Fatalf("expected drops with at most 5 in flight: dropped=%d max=%d", res.Dropped, res.MaxInFlight)
+	}
+}
+
+func TestOpenLoopKeepsFailuresApart(t *testing.T) {
+	o := NewOpenLoop(NewConstant(200), 100*time.Millisecond, 0)
+	res := o.Run(context.Background(), func(i int) error {
+		if i%2 == 1 {
+			time.Sleep(20 * time.Millisecond)
+			return errors.New("failed")
+		}
+		return nil
+	})
+	if res.Failed == 0 || res.Completed+res.Failed != res.Scheduled {
+		t.Fatalf("unexpected counts: scheduled=%d completed=%d failed=%d", res.Scheduled, res.Completed, res.Failed)
+	}
+	if slowest := res.Latency.Summary().Max; slowest >= 20*time.Millisecond {
+		t.Errorf("latency of the completed requests up to %v, want the failed ones left out", slowest)
+	}
+}
+
+func TestPoissonMeanRate(t *testing.T) {
+	p := NewPoisson(1000, rand.New(rand.NewPCG(1, 2)))
+	total := time.Duration(0)
+	n := 100000
+	for range n {
+		total += p.Next()
+	}
+	mean := total / time.Duration(n)
+	if mean < 980*time.Microsecond || mean > 1020*time.Microsecond {
+		t.Errorf("expected a mean gap of ~1ms, got %v", mean)
+	}
+}
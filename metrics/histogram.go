@@ -0,0 +1,165 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"math/bits"
+	"sync/atomic"
+	"time"
+)
+
+// subBucketBits sets the precision of the histogram: values are grouped in buckets
+// whose width is at most 1/2^(subBucketBits-1) of the value (~0.2%), like an HDR histogram
+// with 3 significant digits
+const subBucketBits = 10
+
+const (
+	subBucketCount     = 1 << subBucketBits
+	subBucketHalfCount = subBucketCount / 2
+	bucketsCount       = subBucketCount + (64-subBucketBits)*subBucketHalfCount
+)
+
+// Histogram is a lock-free log-linear histogram of durations.
+// It is safe to record from many goroutines at once.
+type Histogram struct {
+	counts []atomic.Uint64
+	total  atomic.Uint64
+	sum    atomic.Int64
+	min    atomic.Int64
+	max    atomic.Int64
+}
+
+type Summary struct {
+	Count uint64
+	Mean  time.Duration
+	P50   time.Duration
+	P90   time.Duration
+	P99   time.Duration
+	P999  time.Duration
+	Max   time.Duration
+}
+
+func NewHistogram() *Histogram {
+	h := &Histogram{counts: make([]atomic.Uint64, bucketsCount)}
+	h.min.Store(math.MaxInt64)
+	return h
+}
+
+func bucketIndex(v uint64) int {
+	if v < subBucketCount {
+		return int(v)
+	}
+	shift := bits.Len64(v) - subBucketBits
+	sub := v >> shift
+	return subBucketCount + (shift-1)*subBucketHalfCount + int(sub-subBucketHalfCount)
+}
+
+// bucketRange returns the lowest and the highest value that fall into the bucket
+func bucketRange(index int) (uint64, uint64) {
+	if index < subBucketCount {
+		return uint64(index), uint64(index)
+	}
+	shift := (index-subBucketCount)/subBucketHalfCount + 1
+	sub := uint64((index-subBucketCount)%subBucketHalfCount + subBucketHalfCount)
+	return sub << shift, (sub+1)<<shift - 1
+}
+
+func (h *Histogram) Record(d time.Duration) {
+	v := int64(d)
+	if v < 0 {
+		v = 0
+	}
+	h.counts[bucketIndex(uint64(v))].Add(1)
+	h.total.Add(1)
+	h.sum.Add(v)
+	for cur := h.min.Load(); v < cur && !h.min.CompareAndSwap(cur, v); cur = h.min.Load() {
+	}
+	for cur := h.max.Load(); v > cur && !h.max.CompareAndSwap(cur, v); cur = h.max.Load() {
+	}
+}
+
+func (h *Histogram) Count() uint64 {
+	return h.total.Load()
+}
+
+func (h *Histogram) Mean() time.Duration {
+	total := h.total.Load()
+	if total == 0 {
+		return 0
+	}
+	return time.Duration(h.sum.Load() / int64(total))
+}
+
+func (h *Histogram) Min() time.Duration {
+	if h.total.Load() == 0 {
+		return 0
+	}
+	return time.Duration(h.min.Load())
+}
+
+func (h *Histogram) Max() time.Duration {
+	return time.Duration(h.max.Load())
+}
+
+// Percentile returns the value below which p percent (0-100) of the recorded durations fall
+func (h *Histogram) Percentile(p float64) time.Duration {
+	total := h.total.Load()
+	if total == 0 {
+		return 0
+	}
+	p = min(max(p, 0), 100)
+	target := uint64(math.Ceil(p / 100 * float64(total)))
+	target = max(target, 1)
+	cumulative := uint64(0)
+	for i := range h.counts {
+		cumulative += h.counts[i].Load()
+		if cumulative >= target {
+			_, high := bucketRange(i)
+			return min(time.Duration(high), h.Max())
+		}
+	}
+	return h.Max()
+}
+
+func (h *Histogram) Summary() Summary {
+	return Summary{
+		Count: h.Count(),
+		Mean:  h.Mean(),
+		P50:   h.Percentile(50),
+		P90:   h.Percentile(90),
+		P99:   h.Percentile(99),
+		P999:  h.Percentile(99.9),
+		Max:   h.Max(),
+	}
+}
+
+// Dump writes every non-empty bucket with its range in milliseconds, count and cumulative percentile
+func (h *Histogram) Dump(w io.Writer) error {
+	total := h.total.Load()
+	if _, err := fmt.Fprintf(w, "%14s %14s %10s %12s\n", "From(ms)", "To(ms)", "Count", "Percentile"); err != nil {
+		return err
+	}
+	cumulative := uint64(0)
+	for i := range h.counts {
+		count := h.counts[i].Load()
+		if count == 0 {
+			continue
+		}
+		cumulative += count
+		low, high := bucketRange(i)
+		_, err := fmt.Fprintf(w, "%14.4f %14.4f %10d %12.6f\n",
+			float64(low)/float64(time.Millisecond), float64(high)/float64(time.Millisecond),
+			count, 100*float64(cumulative)/float64(total))
+		if err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintf(w, "#[Count = %d, Mean = %v, Min = %v, Max = %v]\n", total, h.Mean(), h.Min(), h.Max())
+	return err
+}
+
+func (s Summary) String() string {
+	return fmt.Sprintf("count=%d mean=%v p50=%v p90=%v p99=%v p99.9=%v max=%v",
+		s.Count, s.Mean, s.P50, s.P90, s.P99, s.P999, s.Max)
+}
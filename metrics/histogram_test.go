@@ -0,0 +1,64 @@
+package metrics
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHistogramPercentiles(t *testing.T) {
+	h := NewHistogram()
+	for i := 1; i <= 1000; i++ {
+		h.Record(time.Duration(i) * time.Millisecond)
+	}
+	within := func(got, want time.Duration) bool {
+		diff := got - want
+		if diff < 0 {
+			diff = -diff
+		}
+		return float64(diff) <= 0.002*float64(want)
+	}
+	for _, tc := range []struct {
+		p    float64
+		want time.Duration
+	}{
+		{50, 500 * time.Millisecond},
+		{90, 900 * time.Millisecond},
+		{99, 990 * time.Millisecond},
+		{99.9, 999 * time.Millisecond},
+		{100, 1000 * time.Millisecond},
+	} {
+		if got := h.Percentile(tc.p); !within(got, tc.want) {
+			t.Errorf("p%v: got %v, want %v", tc.p, got, tc.want)
+		}
+	}
+	if h.Count() != 1000 || h.Max() != time.Second || h.Min() != time.Millisecond {
+		t.Errorf("unexpected count/min/max: %d %v %v", h.Count(), h.Min(), h.Max())
+	}
+	if h.Mean() != 500500*time.Microsecond {
+		t.Errorf("unexpected mean %v", h.Mean())
+	}
+}
+
+func TestHistogramBucketsCoverValues(t *testing.T) {
+	for _, v := range []uint64{0, 1, 1023, 1024, 2047, 2048, 123456789, 1 << 40, 1<<62 + 12345} {
+		low, high := bucketRange(bucketIndex(v))
+		if v < low || v > high {
+			t.Errorf("value %d is outside of its bucket [%d, %d]", v, low, high)
+		}
+	}
+}
+
+func TestHistogramDump(t *testing.T) {
+	h := NewHistogram()
+	h.Record(time.Millisecond)
+	h.Record(2 * time.Millisecond)
+	buf := &bytes.Buffer{}
+	if err := h.Dump(buf); err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Count(buf.String(), "\n"); lines != 4 {
+		t.Errorf("expected header, 2 buckets and a footer, got:\n%s", buf.String())
+	}
+}
@@ -0,0 +1,288 @@
+package results
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"github.com/Volume999/BroadleafSimulation/metrics"
+	"github.com/Volume999/BroadleafSimulation/workload"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Record is the outcome of a single run together with the full parameter tuple.
+// Parameters that do not apply to a run are left at their zero value.
+type Record struct {
+	Benchmark         string  `json:"benchmark"`
+	Scenario          string  `json:"scenario,omitempty"`
+	Mode              string  `json:"mode,omitempty"`
+	Arrival           string  `json:"arrival,omitempty"`
+	OfferedRate       float64 `json:"offeredRate,omitempty"`
+	TableType         string  `json:"tableType,omitempty"`
+	Disk              string  `json:"disk,omitempty"`
+	AccessTimeMs      int     `json:"accessTimeMs,omitempty"`
+	Latency           string  `json:"latency,omitempty"`
+	PaymentGateway    string  `json:"paymentGateway,omitempty"`
+	TaxProvider       string  `json:"taxProvider,omitempty"`
+	Faults            string  `json:"faults,omitempty"`
+	CacheCapacity     int     `json:"cacheCapacity,omitempty"`
+	CacheTTL          string  `json:"cacheTTL,omitempty"`
+	DiskChannels      int     `json:"diskChannels,omitempty"`
+	DiskQueue         int     `json:"diskQueue,omitempty"`
+	Simulator         string  `json:"simulator"`
+	Workflow          string  `json:"workflow"`
+	Parallelism       int     `json:"parallelism"`
+	Goroutines        int     `json:"goroutines"`
+	LimitConnections  int     `json:"limitConnections"`
+	LockCount         int     `json:"lockCount"`
+	Keys              int     `json:"keys"`
+	KeyDist           string  `json:"keyDist,omitempty"`
+	BusinessErrProb   int     `json:"businessErrProb"`
+	TxnMode           string  `json:"txnMode,omitempty"`
+	FailFast          bool    `json:"failFast,omitempty"`
+	RetryPolicy       string  `json:"retryPolicy,omitempty"`
+	FanOut            int     `json:"fanOut,omitempty"`
+	GlobalFanOut      int     `json:"globalFanOut,omitempty"`
+	StageExecution    string  `json:"stageExecution,omitempty"`
+	StageWorkers      int     `json:"stageWorkers,omitempty"`
+	StageQueue        int     `json:"stageQueue,omitempty"`
+	Seed              uint64  `json:"seed"`
+	CpuItersPerUs     float64 `json:"cpuItersPerUs,omitempty"`
+	Executions        uint64  `json:"executions"`
+	Dropped           int     `json:"dropped,omitempty"`
+	Cancelled         int     `json:"cancelled,omitempty"`
+	Committed         int     `json:"committed,omitempty"`
+	RolledBack        int     `json:"rolledBack,omitempty"`
+	Aborted           int     `json:"aborted,omitempty"`
+	Failed            int     `json:"failed,omitempty"`
+	Compensations     int     `json:"compensations,omitempty"`
+	Inconsistent      int     `json:"inconsistent,omitempty"`
+	DiskAccesses      int     `json:"diskAccesses,omitempty"`
+	DiskRejected      int     `json:"diskRejected,omitempty"`
+	DiskWaitMs        float64 `json:"diskWaitMs,omitempty"`
+	DiskWaitP99Ms     float64 `json:"diskWaitP99Ms,omitempty"`
+	DiskQueueLen      float64 `json:"diskQueueLen,omitempty"`
+	DiskPeakQueue     int     `json:"diskPeakQueue,omitempty"`
+	PaymentCalls      int     `json:"paymentCalls,omitempty"`
+	PaymentFailed     int     `json:"paymentFailed,omitempty"`
+	PaymentTimeouts   int     `json:"paymentTimeouts,omitempty"`
+	PaymentMs         float64 `json:"paymentMs,omitempty"`
+	TaxCalls          int     `json:"taxCalls,omitempty"`
+	TaxFailed         int     `json:"taxFailed,omitempty"`
+	TaxTimeouts       int     `json:"taxTimeouts,omitempty"`
+	TaxMs             float64 `json:"taxMs,omitempty"`
+	FaultErrors       int     `json:"faultErrors,omitempty"`
+	FaultSpikes       int     `json:"faultSpikes,omitempty"`
+	FaultTimeouts     int     `json:"faultTimeouts,omitempty"`
+	FaultStalls       int     `json:"faultStalls,omitempty"`
+	CacheHits         int     `json:"cacheHits,omitempty"`
+	CacheMisses       int     `json:"cacheMisses,omitempty"`
+	CacheHitRatio     float64 `json:"cacheHitRatio,omitempty"`
+	CacheExpired      int     `json:"cacheExpired,omitempty"`
+	CacheEvicted      int     `json:"cacheEvicted,omitempty"`
+	CacheInvalidated  int     `json:"cacheInvalidated,omitempty"`
+	Rollbacks         uint64  `json:"rollbacks,omitempty"`
+	RollbackHoldMs    float64 `json:"rollbackHoldMs,omitempty"`
+	Retries           int     `json:"retries,omitempty"`
+	GiveUps           int     `json:"giveUps,omitempty"`
+	AttemptsPerCommit float64 `json:"attemptsPerCommit,omitempty"`
+	ElapsedMs         float64 `json:"elapsedMs"`
+	Throughput        float64 `json:"throughput"`
+	MeanMs            float64 `json:"meanMs"`
+	P50Ms             float64 `json:"p50Ms"`
+	P90Ms             float64 `json:"p90Ms"`
+	P99Ms             float64 `json:"p99Ms"`
+	P999Ms            float64 `json:"p999Ms"`
+	MaxMs             float64 `json:"maxMs"`
+}
+
+func toMs(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// SetRollbackHold fills the rollback fields from the lock-hold times of the rolled back transactions
+func (r *Record) SetRollbackHold(hold metrics.Summary) {
+	r.Rollbacks = hold.Count
+	r.RollbackHoldMs = toMs(hold.Mean)
+}
+
+// SetDiskStats fills the disk fields from the statistics of a queueing disk
+func (r *Record) SetDiskStats(s workload.DiskStats) {
+	r.DiskAccesses, r.DiskRejected = s.Accesses, s.Rejected
+	r.DiskWaitMs, r.DiskWaitP99Ms = toMs(s.Wait.Mean), toMs(s.Wait.P99)
+	r.DiskQueueLen, r.DiskPeakQueue = s.MeanQueue, s.PeakQueue
+}
+
+// SetRemoteStats fills the remote call fields from the providers, a nil provider was not called
+func (r *Record) SetRemoteStats(paymentGateway *workload.RemoteService, taxProvider *workload.RemoteService) {
+	if paymentGateway != nil {
+		s := paymentGateway.Stats()
+		r.PaymentGateway = paymentGateway.Config().String()
+		r.PaymentCalls, r.PaymentFailed, r.PaymentTimeouts, r.PaymentMs = s.Calls, s.Failed, s.Timeouts, toMs(s.Latency.Mean)
+	}
+	if taxProvider != nil {
+		s := taxProvider.Stats()
+		r.TaxProvider = taxProvider.Config().String()
+		r.TaxCalls, r.TaxFailed, r.TaxTimeouts, r.TaxMs = s.Calls, s.Failed, s.Timeouts, toMs(s.Latency.Mean)
+	}
+}
+
+// SetFaultStats fills the fault fields from the injector, nil means no faults were injected
+func (r *Record) SetFaultStats(faults *workload.FaultInjector) {
+	if faults == nil {
+		return
+	}
+	s := faults.Stats()
+	r.Faults = faults.Plan().String()
+	r.FaultErrors, r.FaultSpikes, r.FaultTimeouts, r.FaultStalls = s.Errors, s.Spikes, s.Timeouts, s.Stalls
+}
+
+// SetCacheStats fills the cache fields from the cache, nil means no cache was used
+func (r *Record) SetCacheStats(cache *workload.Cache) {
+	if cache == nil {
+		return
+	}
+	s := cache.Stats()
+	r.CacheCapacity, r.CacheTTL = cache.Capacity(), cache.TTL().String()
+	r.CacheHits, r.CacheMisses, r.CacheHitRatio = s.Hits, s.Misses, s.HitRatio()
+	r.CacheExpired, r.CacheEvicted, r.CacheInvalidated = s.Expired, s.Evicted, s.Invalidated
+}
+
+// SetStats fills throughput and latency fields from the run's wall time and latency histogram
+func (r *Record) SetStats(elapsed time.Duration, latency metrics.Summary) {
+	r.Executions = latency.Count
+	r.ElapsedMs = toMs(elapsed)
+	if elapsed > 0 {
+		r.Throughput = float64(latency.Count) / elapsed.Seconds()
+	}
+	r.MeanMs = toMs(latency.Mean)
+	r.P50Ms = toMs(latency.P50)
+	r.P90Ms = toMs(latency.P90)
+	r.P99Ms = toMs(latency.P99)
+	r.P999Ms = toMs(latency.P999)
+	r.MaxMs = toMs(latency.Max)
+}
+
+var csvHeader = []string{
+	"benchmark", "scenario", "mode", "arrival", "offeredRate", "tableType", "disk", "accessTimeMs", "latency", "paymentGateway", "taxProvider", "faults", "cacheCapacity", "cacheTTL", "diskChannels", "diskQueue", "simulator", "workflow",
+	"parallelism", "goroutines", "limitConnections", "lockCount", "keys", "keyDist", "businessErrProb", "txnMode", "failFast", "retryPolicy", "fanOut", "globalFanOut",
+	"stageExecution", "stageWorkers", "stageQueue", "seed", "cpuItersPerUs",
+	"executions", "dropped", "cancelled", "committed", "rolledBack", "aborted", "failed", "compensations", "inconsistent",
+	"diskAccesses", "diskRejected", "diskWaitMs", "diskWaitP99Ms", "diskQueueLen", "diskPeakQueue",
+	"paymentCalls", "paymentFailed", "paymentTimeouts", "paymentMs", "taxCalls", "taxFailed", "taxTimeouts", "taxMs",
+	"faultErrors", "faultSpikes", "faultTimeouts", "faultStalls",
+	"cacheHits", "cacheMisses", "cacheHitRatio", "cacheExpired", "cacheEvicted", "cacheInvalidated",
+	"rollbacks", "rollbackHoldMs", "retries", "giveUps", "attemptsPerCommit", "elapsedMs", "throughput", "meanMs", "p50Ms", "p90Ms", "p99Ms", "p999Ms", "maxMs",
+}
+
+func (r *Record) csvRow() []string {
+	f := func(v float64) string { return strconv.FormatFloat(v, 'f', -1, 64) }
+	return []string{
+		r.Benchmark, r.Scenario, r.Mode, r.Arrival, f(r.OfferedRate), r.TableType, r.Disk, strconv.Itoa(r.AccessTimeMs), r.Latency, r.PaymentGateway, r.TaxProvider, r.Faults, strconv.Itoa(r.CacheCapacity), r.CacheTTL,
+		strconv.Itoa(r.DiskChannels), strconv.Itoa(r.DiskQueue), r.Simulator, r.Workflow,
+		strconv.Itoa(r.Parallelism), strconv.Itoa(r.Goroutines), strconv.Itoa(r.LimitConnections),
+		strconv.Itoa(r.LockCount), strconv.Itoa(r.Keys), r.KeyDist, strconv.Itoa(r.BusinessErrProb), r.TxnMode, strconv.FormatBool(r.FailFast), r.RetryPolicy,
+		strconv.Itoa(r.FanOut), strconv.Itoa(r.GlobalFanOut), r.StageExecution, strconv.Itoa(r.StageWorkers), strconv.Itoa(r.StageQueue),
+		strconv.FormatUint(r.Seed, 10), f(r.CpuItersPerUs),
+		strconv.FormatUint(r.Executions, 10), strconv.Itoa(r.Dropped), strconv.Itoa(r.Cancelled),
+		strconv.Itoa(r.Committed), strconv.Itoa(r.RolledBack), strconv.Itoa(r.Aborted), strconv.Itoa(r.Failed), strconv.Itoa(r.Compensations), strconv.Itoa(r.Inconsistent),
+		strconv.Itoa(r.DiskAccesses), strconv.Itoa(r.DiskRejected), f(r.DiskWaitMs), f(r.DiskWaitP99Ms), f(r.DiskQueueLen), strconv.Itoa(r.DiskPeakQueue),
+		strconv.Itoa(r.PaymentCalls), strconv.Itoa(r.PaymentFailed), strconv.Itoa(r.PaymentTimeouts), f(r.PaymentMs),
+		strconv.Itoa(r.TaxCalls), strconv.Itoa(r.TaxFailed), strconv.Itoa(r.TaxTimeouts), f(r.TaxMs),
+		strconv.Itoa(r.FaultErrors), strconv.Itoa(r.FaultSpikes), strconv.Itoa(r.FaultTimeouts), strconv.Itoa(r.FaultStalls),
+		strconv.Itoa(r.CacheHits), strconv.Itoa(r.CacheMisses), f(r.CacheHitRatio), strconv.Itoa(r.CacheExpired), strconv.Itoa(r.CacheEvicted), strconv.Itoa(r.CacheInvalidated),
+		strconv.FormatUint(r.Rollbacks, 10), f(r.RollbackHoldMs), strconv.Itoa(r.Retries), strconv.Itoa(r.GiveUps), f(r.AttemptsPerCommit), f(r.ElapsedMs), f(r.Throughput), f(r.MeanMs),
+		f(r.P50Ms), f(r.P90Ms), f(r.P99Ms), f(r.P999Ms), f(r.MaxMs),
+	}
+}
+
+func WriteCSV(w io.Writer, records []Record) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(csvHeader); err != nil {
+		return err
+	}
+	for i := range records {
+		if err := cw.Write(records[i].csvRow()); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func WriteJSON(w io.Writer, records []Record) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(records)
+}
+
+// WriteJSONLines writes one JSON object per line
+func WriteJSONLines(w io.Writer, records []Record) error {
+	enc := json.NewEncoder(w)
+	for i := range records {
+		if err := enc.Encode(&records[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteFile writes the records in the format given by the file extension: .csv, .json or .jsonl
+func WriteFile(path string, records []Record) error {
+	var write func(io.Writer, []Record) error
+	switch ext := filepath.Ext(path); ext {
+	case ".csv":
+		write = WriteCSV
+	case ".json":
+		write = WriteJSON
+	case ".jsonl":
+		write = WriteJSONLines
+	default:
+		return fmt.Errorf("unsupported results format %q", ext)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err = write(f, records); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Collector gathers records from concurrent runs. Records stored under the same key
+// replace each other, which keeps only the last of the repeated runs of a benchmark.
+type Collector struct {
+	mu      sync.Mutex
+	keys    []string
+	records map[string]Record
+}
+
+func NewCollector() *Collector {
+	return &Collector{records: make(map[string]Record)}
+}
+
+func (c *Collector) Set(key string, r Record) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.records[key]; !ok {
+		c.keys = append(c.keys, key)
+	}
+	c.records[key] = r
+}
+
+// Records returns the records in the order their keys were first set
+func (c *Collector) Records() []Record {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	res := make([]Record, 0, len(c.keys))
+	for _, key := range c.keys {
+		res = append(res, c.records[key])
+	}
+	return res
+}
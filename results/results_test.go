@@ -0,0 +1,136 @@
+package results
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// filledRecord returns a record whose fields all have distinct, non-zero values,
+// so a column written from the wrong field does not go unnoticed
+func filledRecord() Record {
+	var r Record
+	v := reflect.ValueOf(&r).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Field(i)
+		switch field.Kind() {
+		case reflect.String:
+			field.SetString(v.Type().Field(i).Name + "-value")
+		case reflect.Int:
+			field.SetInt(int64(i + 1))
+		case reflect.Uint64:
+			field.SetUint(uint64(i + 1))
+		case reflect.Float64:
+			field.SetFloat(float64(i) + 0.25)
+		case reflect.Bool:
+			field.SetBool(true)
+		default:
+			panic("unsupported field kind " + field.Kind().String())
+		}
+	}
+	return r
+}
+
+// jsonName is the name of the field in JSON, which is also its CSV column
+func jsonName(f reflect.StructField) string {
+	name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+	return name
+}
+
+func TestWriteCSV(t *testing.T) {
+	rec := filledRecord()
+	var buf bytes.Buffer
+	if err := WriteCSV(&buf, []Record{rec}); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 2 {
+		t.Fatalf("expected a header and 1 row, got %d rows", len(rows))
+	}
+	header, row := rows[0], rows[1]
+	typ := reflect.TypeOf(rec)
+	if len(header) != typ.NumField() || len(row) != typ.NumField() {
+		t.Fatalf("header has %d columns and row %d, want one per field (%d)", len(header), len(row), typ.NumField())
+	}
+	v := reflect.ValueOf(rec)
+	for i, column := range header {
+		f := typ.Field(i)
+		if want := jsonName(f); column != want {
+			t.Errorf("column %d is %q, want %q of field %s", i, column, want, f.Name)
+			continue
+		}
+		var got any
+		switch f.Type.Kind() {
+		case reflect.String:
+			got = row[i]
+		case reflect.Int:
+			got, _ = strconv.Atoi(row[i])
+		case reflect.Uint64:
+			got, _ = strconv.ParseUint(row[i], 10, 64)
+		case reflect.Float64:
+			got, _ = strconv.ParseFloat(row[i], 64)
+		case reflect.Bool:
+			got, _ = strconv.ParseBool(row[i])
+		}
+		if want := v.Field(i).Interface(); got != want {
+			t.Errorf("column %s is %q, want %v", column, row[i], want)
+		}
+	}
+}
+
+func TestWriteJSONRoundTrips(t *testing.T) {
+	records := []Record{filledRecord(), {Benchmark: "zero"}}
+	for name, write := range map[string]func(*bytes.Buffer) error{
+		"json":  func(buf *bytes.Buffer) error { return WriteJSON(buf, records) },
+		"jsonl": func(buf *bytes.Buffer) error { return WriteJSONLines(buf, records) },
+	} {
+		var buf bytes.Buffer
+		if err := write(&buf); err != nil {
+			t.Fatal(err)
+		}
+		var got []Record
+		if name == "json" {
+			if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+				t.Fatal(err)
+			}
+		} else {
+			dec := json.NewDecoder(&buf)
+			for dec.More() {
+				var r Record
+				if err := dec.Decode(&r); err != nil {
+					t.Fatal(err)
+				}
+				got = append(got, r)
+			}
+		}
+		if !reflect.DeepEqual(got, records) {
+			t.Errorf("%s: records do not round-trip:\n got %+v\nwant %+v", name, got, records)
+		}
+	}
+}
+
+func TestWriteFile(t *testing.T) {
+	dir := t.TempDir()
+	records := []Record{filledRecord()}
+	for _, ext := range []string{".csv", ".json", ".jsonl"} {
+		path := filepath.Join(dir, "results"+ext)
+		if err := WriteFile(path, records); err != nil {
+			t.Fatalf("%s: %v", ext, err)
+		}
+		if data, err := os.ReadFile(path); err != nil || len(data) == 0 {
+			t.Errorf("%s: nothing written (%v)", ext, err)
+		}
+	}
+	if err := WriteFile(filepath.Join(dir, "results.txt"), records); err == nil {
+		t.Error("expected an error for an unsupported format")
+	}
+}
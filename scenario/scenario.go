@@ -0,0 +1,193 @@
+package scenario
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	SimulatedBenchmark = "simulated"
+	AsyncDBBenchmark   = "asyncdb"
+)
+
+var ErrInvalidScenario = errors.New("invalid scenario")
+
+// Scenario describes a parameter grid for one of the benchmarks.
+// Every combination of the parameter values is a separate run.
+// Settings hold values shared by all runs (e.g. connection strings).
+type Scenario struct {
+	Name        string            `json:"name"`
+	Description string            `json:"description,omitempty"`
+	Benchmark   string            `json:"benchmark"`
+	Settings    map[string]string `json:"settings,omitempty"`
+	Parameters  []Parameter       `json:"parameters"`
+}
+
+type Parameter struct {
+	Name   string        `json:"name"`
+	Values []interface{} `json:"values"`
+}
+
+// Run is a single combination of parameter values
+type Run struct {
+	names  []string
+	values map[string]interface{}
+}
+
+func Load(path string) (*Scenario, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(data)
+}
+
+func Parse(data []byte) (*Scenario, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	dec.DisallowUnknownFields()
+	s := &Scenario{}
+	if err := dec.Decode(s); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidScenario, err)
+	}
+	if err := s.validate(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *Scenario) validate() error {
+	switch s.Benchmark {
+	case SimulatedBenchmark, AsyncDBBenchmark:
+	default:
+		return fmt.Errorf("%w: unknown benchmark %q", ErrInvalidScenario, s.Benchmark)
+	}
+	seen := make(map[string]bool, len(s.Parameters))
+	for _, p := range s.Parameters {
+		if p.Name == "" {
+			return fmt.Errorf("%w: parameter without a name", ErrInvalidScenario)
+		}
+		if seen[p.Name] {
+			return fmt.Errorf("%w: duplicate parameter %q", ErrInvalidScenario, p.Name)
+		}
+		seen[p.Name] = true
+		if len(p.Values) == 0 {
+			return fmt.Errorf("%w: parameter %q has no values", ErrInvalidScenario, p.Name)
+		}
+		for _, v := range p.Values {
+			switch v.(type) {
+			case string, json.Number:
+			default:
+				return fmt.Errorf("%w: parameter %q has a value that is neither a string nor a number", ErrInvalidScenario, p.Name)
+			}
+		}
+	}
+	return nil
+}
+
+// Require checks that the scenario defines all the given parameters
+func (s *Scenario) Require(names ...string) error {
+	for _, name := range names {
+		found := false
+		for _, p := range s.Parameters {
+			if p.Name == name {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("%w: scenario %q is missing parameter %q", ErrInvalidScenario, s.Name, name)
+		}
+	}
+	return nil
+}
+
+// Runs expands the parameter grid. The first parameter changes the slowest.
+func (s *Scenario) Runs() []Run {
+	names := make([]string, len(s.Parameters))
+	grid := make([][]interface{}, len(s.Parameters))
+	for i, p := range s.Parameters {
+		names[i] = p.Name
+		grid[i] = p.Values
+	}
+	combinations := Combinations(grid...)
+	runs := make([]Run, 0, len(combinations))
+	for _, combination := range combinations {
+		values := make(map[string]interface{}, len(names))
+		for i, name := range names {
+			values[name] = combination[i]
+		}
+		runs = append(runs, Run{names: names, values: values})
+	}
+	return runs
+}
+
+func Combinations(configs ...[]interface{}) [][]interface{} {
+	if len(configs) == 0 {
+		return [][]interface{}{}
+	}
+	if len(configs) == 1 {
+		res := make([][]interface{}, 0)
+		for _, c := range configs[0] {
+			res = append(res, []interface{}{c})
+		}
+		return res
+	}
+	config := configs[0]
+	otherConfigs := Combinations(configs[1:]...)
+	res := make([][]interface{}, 0)
+	for _, c := range config {
+		for _, oc := range otherConfigs {
+			res = append(res, append([]interface{}{c}, oc...))
+		}
+	}
+	return res
+}
+
+func (r Run) String(name string) string {
+	switch v := r.values[name].(type) {
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	}
+	panic("scenario: missing parameter " + name)
+}
+
+func (r Run) Int(name string) int {
+	v, err := strconv.Atoi(r.String(name))
+	if err != nil {
+		panic(fmt.Sprintf("scenario: parameter %s is not an integer: %v", name, err))
+	}
+	return v
+}
+
+// StringOr returns the value of an optional parameter, or def if the scenario does not have it
+func (r Run) StringOr(name string, def string) string {
+	if _, ok := r.values[name]; !ok {
+		return def
+	}
+	return r.String(name)
+}
+
+// IntOr returns the value of an optional integer parameter, or def if the scenario does not have it
+func (r Run) IntOr(name string, def int) int {
+	if _, ok := r.values[name]; !ok {
+		return def
+	}
+	return r.Int(name)
+}
+
+// Name formats the run as name=value pairs in parameter order
+func (r Run) Name() string {
+	parts := make([]string, len(r.names))
+	for i, name := range r.names {
+		parts[i] = name + "=" + r.String(name)
+	}
+	return strings.Join(parts, "/")
+}
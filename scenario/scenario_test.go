@@ -0,0 +1,55 @@
+package scenario
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestRunsExpandGrid(t *testing.T) {
+	s, err := Parse([]byte(`{
+		"name": "grid",
+		"benchmark": "asyncdb",
+		"parameters": [
+			{"name": "wfType", "values": ["sequential", "concurrent"]},
+			{"name": "parallelism", "values": [1, 10, 100]}
+		]
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	runs := s.Runs()
+	if len(runs) != 6 {
+		t.Fatalf("expected 6 runs, got %d", len(runs))
+	}
+	if name := runs[1].Name(); name != "wfType=sequential/parallelism=10" {
+		t.Errorf("unexpected run name %q", name)
+	}
+	if p := runs[5].Int("parallelism"); p != 100 {
+		t.Errorf("expected parallelism 100, got %d", p)
+	}
+}
+
+func TestParseRejectsInvalidScenarios(t *testing.T) {
+	for _, data := range []string{
+		`{"name": "x", "benchmark": "unknown", "parameters": []}`,
+		`{"name": "x", "benchmark": "asyncdb", "parameters": [{"name": "keys", "values": []}]}`,
+		`{"name": "x", "benchmark": "asyncdb", "parameters": [{"name": "keys", "values": [true]}]}`,
+		`{"name": "x", "benchmark": "asyncdb", "parametres": []}`,
+	} {
+		if _, err := Parse([]byte(data)); err == nil {
+			t.Errorf("expected an error for %s", data)
+		}
+	}
+}
+
+func TestCheckedInScenariosLoad(t *testing.T) {
+	paths, err := filepath.Glob("../scenarios/*.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range paths {
+		if _, err := Load(path); err != nil {
+			t.Errorf("%s: %v", path, err)
+		}
+	}
+}
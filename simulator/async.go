@@ -1,120 +1,164 @@
 package simulator
 
 import (
+	"context"
+	"errors"
 	"github.com/Volume999/BroadleafSimulation/workload"
-	"math/rand"
 	"sync"
 )
 
 type AsyncSimulator struct {
-	config *Config
-	disk   workload.DiskAccessSimulator
+	config    *Config
+	disk      workload.DiskAccessSimulator
+	providers Providers
 }
 
-func NewAsyncSimulator(config *Config, disk workload.DiskAccessSimulator) *AsyncSimulator {
+func NewAsyncSimulator(config *Config, disk workload.DiskAccessSimulator, providers Providers) *AsyncSimulator {
 	return &AsyncSimulator{
-		config: config,
-		disk:   disk,
+		config:    config,
+		disk:      disk,
+		providers: providers,
 	}
 }
 
-func (s *AsyncSimulator) ValidateCheckout() {
-}
-
-func (s *AsyncSimulator) ValidateAvailability() {
-	orderItemsCnt := s.config.OrderItemsCnt
+// spawnN runs f(0), ..., f(n-1) in n goroutines, waits for them and joins their errors.
+// No new goroutines are spawned once ctx is done.
+func spawnN(ctx context.Context, n int, f func(i int) error) error {
 	wg := &sync.WaitGroup{}
-	wg.Add(orderItemsCnt)
-	for range orderItemsCnt {
-		go func() {
-			defer wg.Done()
-			s.disk.SimulateDiskAccess()
-			workload.SimulateCpuLoad(100)
-		}()
-	}
-	wg.Wait()
-	skuItemsCnt := s.config.SKUItemsCnt
-	wg.Add(skuItemsCnt)
-	for range skuItemsCnt {
+	mu := &sync.Mutex{}
+	var spawnErr error
+	for i := range n {
+		if err := ctx.Err(); err != nil {
+			spawnErr = err
+			break
+		}
+		wg.Add(1)
 		go func() {
 			defer wg.Done()
-			s.disk.SimulateDiskAccess()
-			workload.SimulateCpuLoad(100)
+			if err := f(i); err != nil {
+				mu.Lock()
+				spawnErr = errors.Join(spawnErr, err)
+				mu.Unlock()
+			}
 		}()
 	}
 	wg.Wait()
-	workload.SimulateCpuLoad(10000)
+	return spawnErr
 }
 
-func (s *AsyncSimulator) VerifyCustomer() {
-	wg := &sync.WaitGroup{}
-	s.disk.SimulateDiskAccess()
-	workload.SimulateCpuLoad(100)
-	appliedOffersCnt := s.config.AppliedOffersCnt
-	wg.Add(appliedOffersCnt)
-	for range appliedOffersCnt {
-		go func() {
-			defer wg.Done()
-			isLimitedUse := rand.Intn(2) == 0
-			if isLimitedUse {
-				s.disk.SimulateDiskAccess()
-				workload.SimulateCpuLoad(1000)
-			}
-		}()
+func (s *AsyncSimulator) ValidateCheckout(ctx context.Context) error {
+	return nil
+}
+
+func (s *AsyncSimulator) ValidateAvailability(ctx context.Context) error {
+	err := spawnN(ctx, s.config.OrderItemsCnt, func(i int) error {
+		if err := s.disk.SimulateDiskAccess(workload.WithTableAccess(ctx, "Items", i, false)); err != nil {
+			return err
+		}
+		workload.SimulateCpuLoad(cpuLightUs)
+		return nil
+	})
+	if err != nil {
+		return err
 	}
-	wg.Wait()
+	err = spawnN(ctx, s.config.SKUItemsCnt, func(i int) error {
+		if err := s.disk.SimulateDiskAccess(workload.WithTableAccess(ctx, "StockKeepingUnits", i, false)); err != nil {
+			return err
+		}
+		workload.SimulateCpuLoad(cpuLightUs)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	workload.SimulateCpuLoad(cpuHeavyUs)
+	return nil
 }
 
-func (s *AsyncSimulator) ValidatePayment() {
-	s.disk.SimulateDiskAccess()
-	paymentsCnt := s.config.PaymentsCnt
-	wg := &sync.WaitGroup{}
-	wg.Add(paymentsCnt)
-	for range paymentsCnt {
-		go func() {
-			defer wg.Done()
-			isActive := rand.Intn(10) < 4
-			if isActive {
-				s.disk.SimulateDiskAccess()
-				workload.SimulateCpuLoad(10000)
-				s.disk.SimulateDiskAccess()
-				s.disk.SimulateDiskAccess()
-			}
-		}()
+func (s *AsyncSimulator) VerifyCustomer(ctx context.Context) error {
+	if err := s.disk.SimulateDiskAccess(ctx); err != nil {
+		return err
 	}
-	wg.Wait()
+	workload.SimulateCpuLoad(cpuLightUs)
+	// Random choices are made before spawning, so they do not depend on goroutine scheduling
+	r := ActivityRand(ctx, "VerifyCustomer")
+	isLimitedUse := make([]bool, s.config.AppliedOffersCnt)
+	for i := range isLimitedUse {
+		isLimitedUse[i] = r.IntN(2) == 0
+	}
+	return spawnN(ctx, s.config.AppliedOffersCnt, func(i int) error {
+		if isLimitedUse[i] {
+			if err := s.disk.SimulateDiskAccess(ctx); err != nil {
+				return err
+			}
+			workload.SimulateCpuLoad(cpuMediumUs)
+		}
+		return nil
+	})
 }
 
-func (s *AsyncSimulator) ValidateProductOption() {
+func (s *AsyncSimulator) ValidatePayment(ctx context.Context) error {
+	if err := s.disk.SimulateDiskAccess(ctx); err != nil {
+		return err
+	}
+	r := ActivityRand(ctx, "ValidatePayment")
+	isActive := make([]bool, s.config.PaymentsCnt)
+	for i := range isActive {
+		isActive[i] = r.IntN(10) < 4
+	}
+	return spawnN(ctx, s.config.PaymentsCnt, func(i int) error {
+		if isActive[i] {
+			if err := s.disk.SimulateDiskAccess(ctx); err != nil {
+				return err
+			}
+			if err := callRemote(ctx, s.providers.PaymentGateway, i); err != nil {
+				return err
+			}
+			workload.SimulateCpuLoad(cpuHeavyUs)
+			if err := s.disk.SimulateDiskAccess(ctx); err != nil {
+				return err
+			}
+			return s.disk.SimulateDiskAccess(ctx)
+		}
+		return nil
+	})
+}
 
+func (s *AsyncSimulator) ValidateProductOption(ctx context.Context) error {
+	return nil
 }
 
-func (s *AsyncSimulator) RecordOffer() {
-	s.disk.SimulateDiskAccess()
-	workload.SimulateCpuLoad(10000)
+func (s *AsyncSimulator) RecordOffer(ctx context.Context) error {
+	if err := s.disk.SimulateDiskAccess(ctx); err != nil {
+		return err
+	}
+	workload.SimulateCpuLoad(cpuHeavyUs)
+	return nil
 }
 
-func (s *AsyncSimulator) CommitTax() {
-	s.disk.SimulateDiskAccess()
-	s.disk.SimulateDiskAccess()
+func (s *AsyncSimulator) CommitTax(ctx context.Context) error {
+	if err := s.disk.SimulateDiskAccess(ctx); err != nil {
+		return err
+	}
+	if err := callRemote(ctx, s.providers.TaxProvider, 0); err != nil {
+		return err
+	}
+	return s.disk.SimulateDiskAccess(ctx)
 }
 
-func (s *AsyncSimulator) DecrementInventory() {
-	s.disk.SimulateDiskAccess()
-	orderItemsCnt := s.config.OrderItemsCnt
-	wg := &sync.WaitGroup{}
-	wg.Add(orderItemsCnt)
-	for range orderItemsCnt {
-		go func() {
-			defer wg.Done()
-			s.disk.SimulateDiskAccess()
-			workload.SimulateCpuLoad(1000)
-			s.disk.SimulateDiskAccess()
-		}()
+func (s *AsyncSimulator) DecrementInventory(ctx context.Context) error {
+	if err := s.disk.SimulateDiskAccess(ctx); err != nil {
+		return err
 	}
-	wg.Wait()
+	return spawnN(ctx, s.config.OrderItemsCnt, func(i int) error {
+		if err := s.disk.SimulateDiskAccess(workload.WithTableAccess(ctx, "Items", i, true)); err != nil {
+			return err
+		}
+		workload.SimulateCpuLoad(cpuMediumUs)
+		return s.disk.SimulateDiskAccess(workload.WithTableAccess(ctx, "StockKeepingUnits", i, true))
+	})
 }
 
-func (s *AsyncSimulator) CompleteOrder() {
-	s.disk.SimulateDiskAccess()
+func (s *AsyncSimulator) CompleteOrder(ctx context.Context) error {
+	return s.disk.SimulateDiskAccess(ctx)
 }
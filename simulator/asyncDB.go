@@ -1,65 +1,254 @@
 package simulator
 
 import (
+	"context"
 	"errors"
 	"github.com/Volume999/AsyncDB/asyncdb"
+	"github.com/Volume999/BroadleafSimulation/tracing"
 	"github.com/Volume999/BroadleafSimulation/workload"
 	"log"
-	"math/rand"
+	"math/rand/v2"
+	"slices"
+	"strings"
+	"sync/atomic"
 )
 
 var ErrBusinessLogic = errors.New("business logic error")
 
 type TableReadWriteSimulator interface {
-	ReadN(table string, keys []int) error
-	WriteN(table string, keys []int) error
+	ReadN(ctx context.Context, table string, keys []int) error
+	WriteN(ctx context.Context, table string, keys []int) error
+}
+
+// InitialValue is the value of every key of the tables after they are set up
+const InitialValue = "value"
+
+// Saga writes put the value of their order on top of the values they overwrote, e.g. "order-2|order-1|value",
+// so the compensation of a step takes out exactly its own write, even after a later saga wrote the key.
+// At most maxSagaValues values are kept, a key whose values were all taken out holds InitialValue.
+const (
+	sagaValueSep  = "|"
+	maxSagaValues = 16
+)
+
+type writeKey struct{}
+
+type sagaWrite struct {
+	value      string
+	compensate bool
+}
+
+// WithSagaWrite makes the table writes made with ctx put value on top of the values of the keys, see TopValue
+func WithSagaWrite(ctx context.Context, value string) context.Context {
+	return context.WithValue(ctx, writeKey{}, sagaWrite{value: value})
+}
+
+// WithCompensation makes the table writes made with ctx take value, written with WithSagaWrite, out of the keys
+func WithCompensation(ctx context.Context, value string) context.Context {
+	return context.WithValue(ctx, writeKey{}, sagaWrite{value: value, compensate: true})
+}
+
+// ReadsBeforeWrite reports whether the table writes made with ctx depend on the values of the keys,
+// which they then read in the same transaction
+func ReadsBeforeWrite(ctx context.Context) bool {
+	_, ok := ctx.Value(writeKey{}).(sagaWrite)
+	return ok
+}
+
+// WriteValue returns the value a table write made with ctx puts into a key holding old.
+// Writes put InitialValue, unless they are saga writes or compensations.
+func WriteValue(ctx context.Context, old string) string {
+	w, ok := ctx.Value(writeKey{}).(sagaWrite)
+	if !ok {
+		return InitialValue
+	}
+	values := strings.Split(old, sagaValueSep)
+	if !w.compensate {
+		// A key written twice by the order, e.g. a duplicate key, holds its value once
+		if values[0] == w.value {
+			return old
+		}
+		return strings.Join(append([]string{w.value}, values[:min(len(values), maxSagaValues-1)]...), sagaValueSep)
+	}
+	values = slices.DeleteFunc(values, func(v string) bool { return v == w.value })
+	if len(values) == 0 {
+		return InitialValue
+	}
+	return strings.Join(values, sagaValueSep)
+}
+
+// TopValue returns the value of a key holding stored, i.e. the last saga write that was not compensated
+func TopValue(stored string) string {
+	top, _, _ := strings.Cut(stored, sagaValueSep)
+	return top
 }
 
 type ConcTableReadWriteSimulator struct {
-	db  *asyncdb.AsyncDB
-	ctx *asyncdb.ConnectionContext
+	db     *asyncdb.AsyncDB
+	ctx    *asyncdb.ConnectionContext
+	fanOut int
+	global *FanOutLimiter
+	// free is the one request the transaction may have in flight beyond the global limit
+	free chan struct{}
 }
 
-func NewConcTableReadWriteSimulator(db *asyncdb.AsyncDB, ctx *asyncdb.ConnectionContext) *ConcTableReadWriteSimulator {
-	return &ConcTableReadWriteSimulator{db: db, ctx: ctx}
+func NewConcTableReadWriteSimulator(db *asyncdb.AsyncDB, ctx *asyncdb.ConnectionContext, options ...func(*ConcTableReadWriteSimulator)) *ConcTableReadWriteSimulator {
+	c := &ConcTableReadWriteSimulator{db: db, ctx: ctx}
+	for _, option := range options {
+		option(c)
+	}
+	return c
 }
 
-func (c ConcTableReadWriteSimulator) ReadN(table string, keys []int) error {
-	var readErr error
-	n := len(keys)
-	errChan := make(chan error, n)
-	defer close(errChan)
-	for _, key := range keys {
-		go func() {
+// WithFanOut limits the requests one ReadN or WriteN call has in flight, 0 means no limit
+func WithFanOut(limit int) func(*ConcTableReadWriteSimulator) {
+	return func(c *ConcTableReadWriteSimulator) {
+		c.fanOut = limit
+	}
+}
+
+// WithGlobalFanOut limits the requests of all simulators sharing the limiter, see FanOutLimiter
+func WithGlobalFanOut(limiter *FanOutLimiter) func(*ConcTableReadWriteSimulator) {
+	return func(c *ConcTableReadWriteSimulator) {
+		c.global = limiter
+		c.free = make(chan struct{}, 1)
+		c.free <- struct{}{}
+	}
+}
+
+// FanOutLimiter bounds the DB requests that ConcTableReadWriteSimulators have in flight together.
+// Every simulator, i.e. every transaction, may still have one request in flight beyond the limit:
+// a transaction waiting for a lock holds its slots, so without it the transaction holding the lock
+// could be unable to make progress.
+type FanOutLimiter struct {
+	slots    chan struct{}
+	inFlight atomic.Int64
+	peak     atomic.Int64
+}
+
+func NewFanOutLimiter(limit int) *FanOutLimiter {
+	return &FanOutLimiter{slots: make(chan struct{}, limit)}
+}
+
+// Peak returns the largest number of requests that were in flight at once, including the ones beyond the limit
+func (l *FanOutLimiter) Peak() int {
+	return int(l.peak.Load())
+}
+
+func (l *FanOutLimiter) started() {
+	n := l.inFlight.Add(1)
+	for peak := l.peak.Load(); n > peak && !l.peak.CompareAndSwap(peak, n); peak = l.peak.Load() {
+	}
+}
+
+// ReadN issues a Get for every key in its own goroutine. Once ctx is done, no more Gets are issued
+// and ReadN returns without waiting for the ones in flight.
+func (c ConcTableReadWriteSimulator) ReadN(ctx context.Context, table string, keys []int) error {
+	return c.forEachKey(ctx, keys, func(key int) error {
+		end := tracing.Start(ctx, tracing.CatDB, "Get "+table)
+		res := <-c.db.Get(c.ctx, table, key)
+		end(res.Err)
+		return res.Err
+	})
+}
+
+// WriteN issues a Put for every key in its own goroutine, see ReadN for cancellation.
+// Writes that depend on the values of the keys (see ReadsBeforeWrite) Get every key before its Put.
+func (c ConcTableReadWriteSimulator) WriteN(ctx context.Context, table string, keys []int) error {
+	return c.forEachKey(ctx, keys, func(key int) error {
+		var old string
+		if ReadsBeforeWrite(ctx) {
+			end := tracing.Start(ctx, tracing.CatDB, "Get "+table)
 			res := <-c.db.Get(c.ctx, table, key)
-			errChan <- res.Err
-		}()
+			end(res.Err)
+			if res.Err != nil {
+				return res.Err
+			}
+			old, _ = res.Data.(string)
+		}
+		end := tracing.Start(ctx, tracing.CatDB, "Put "+table)
+		res := <-c.db.Put(c.ctx, table, key, WriteValue(ctx, old))
+		end(res.Err)
+		return res.Err
+	})
+}
+
+// acquire waits until the call may issue another request and returns the function that releases its slots.
+// local holds the slots of the call.
+func (c ConcTableReadWriteSimulator) acquire(ctx context.Context, local chan struct{}) (func(), error) {
+	if local != nil {
+		select {
+		case local <- struct{}{}:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
 	}
-	for i := 0; i < n; i++ {
-		if err := <-errChan; err != nil {
-			readErr = errors.Join(readErr, err)
+	releaseLocal := func() {
+		if local != nil {
+			<-local
 		}
 	}
-	return readErr
+	if c.global == nil {
+		return releaseLocal, nil
+	}
+	select {
+	case <-c.free:
+		c.global.started()
+		return func() {
+			c.global.inFlight.Add(-1)
+			c.free <- struct{}{}
+			releaseLocal()
+		}, nil
+	case c.global.slots <- struct{}{}:
+		c.global.started()
+		return func() {
+			c.global.inFlight.Add(-1)
+			<-c.global.slots
+			releaseLocal()
+		}, nil
+	case <-ctx.Done():
+		releaseLocal()
+		return nil, ctx.Err()
+	}
 }
 
-func (c ConcTableReadWriteSimulator) WriteN(table string, keys []int) error {
-	var writeErr error
-	n := len(keys)
-	errChan := make(chan error, n)
-	defer close(errChan)
+func (c ConcTableReadWriteSimulator) forEachKey(ctx context.Context, keys []int, op func(key int) error) error {
+	var opErr error
+	// The channel is buffered for all keys, so goroutines finishing after cancellation do not block
+	errChan := make(chan error, len(keys))
+	var local chan struct{}
+	if c.fanOut > 0 && c.fanOut < len(keys) {
+		local = make(chan struct{}, c.fanOut)
+	}
+	spawned := 0
 	for _, key := range keys {
+		if ctx.Err() != nil {
+			break
+		}
+		release, err := c.acquire(ctx, local)
+		if err != nil {
+			break
+		}
+		spawned++
 		go func() {
-			res := <-c.db.Put(c.ctx, table, key, "value")
-			errChan <- res.Err
+			defer release()
+			errChan <- op(key)
 		}()
 	}
-	for i := 0; i < n; i++ {
-		if err := <-errChan; err != nil {
-			writeErr = errors.Join(writeErr, err)
+	for i := 0; i < spawned; i++ {
+		select {
+		case err := <-errChan:
+			if err != nil {
+				opErr = errors.Join(opErr, err)
+			}
+		case <-ctx.Done():
+			return errors.Join(opErr, ctx.Err())
 		}
 	}
-	return writeErr
+	if spawned < len(keys) {
+		opErr = errors.Join(opErr, ctx.Err())
+	}
+	return opErr
 }
 
 type SyncTableReadWriteSimulator struct {
@@ -71,21 +260,56 @@ func NewSyncTableReadWriteSimulator(db *asyncdb.AsyncDB, ctx *asyncdb.Connection
 	return &SyncTableReadWriteSimulator{db: db, ctx: ctx}
 }
 
-func (s SyncTableReadWriteSimulator) ReadN(table string, keys []int) error {
+func (s SyncTableReadWriteSimulator) ReadN(ctx context.Context, table string, keys []int) error {
 	for _, key := range keys {
-		res := <-s.db.Get(s.ctx, table, key)
-		if res.Err != nil {
-			return res.Err
+		if _, err := s.get(ctx, table, key); err != nil {
+			return err
 		}
 	}
 	return nil
 }
 
-func (s SyncTableReadWriteSimulator) WriteN(table string, keys []int) error {
+// get reads the value of a key
+func (s SyncTableReadWriteSimulator) get(ctx context.Context, table string, key int) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	end := tracing.Start(ctx, tracing.CatDB, "Get "+table)
+	select {
+	case res := <-s.db.Get(s.ctx, table, key):
+		end(res.Err)
+		value, _ := res.Data.(string)
+		return value, res.Err
+	case <-ctx.Done():
+		end(ctx.Err())
+		return "", ctx.Err()
+	}
+}
+
+// WriteN puts the keys one after another. Writes that depend on the values of the keys
+// (see ReadsBeforeWrite) Get every key before its Put.
+func (s SyncTableReadWriteSimulator) WriteN(ctx context.Context, table string, keys []int) error {
 	for _, key := range keys {
-		res := <-s.db.Put(s.ctx, table, key, "value")
-		if res.Err != nil {
-			return res.Err
+		var old string
+		if ReadsBeforeWrite(ctx) {
+			var err error
+			if old, err = s.get(ctx, table, key); err != nil {
+				return err
+			}
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		end := tracing.Start(ctx, tracing.CatDB, "Put "+table)
+		select {
+		case res := <-s.db.Put(s.ctx, table, key, WriteValue(ctx, old)):
+			end(res.Err)
+			if res.Err != nil {
+				return res.Err
+			}
+		case <-ctx.Done():
+			end(ctx.Err())
+			return ctx.Err()
 		}
 	}
 	return nil
@@ -95,133 +319,155 @@ type AsyncDBSimulator struct {
 	rw              TableReadWriteSimulator
 	l               *log.Logger
 	config          *Config
-	keys            int
 	businessErrProb int
+	providers       Providers
 }
 
-func RandomIntInRange(i int, i2 int) int {
-	return rand.Intn(i2-i+1) + i
-}
-
-func RandomChance(prob int) bool {
-	return rand.Intn(100) < prob
-}
-
-func (a *AsyncDBSimulator) ValidateCheckout() error {
-	workload.SimulateCpuLoad(100)
+func (a *AsyncDBSimulator) ValidateCheckout(ctx context.Context) error {
+	workload.SimulateCpuLoad(cpuLightUs)
 	// One DB call for checking isCompleted
-	if err := a.rw.ReadN("Orders", a.config.keys.Orders); err != nil {
+	if err := a.rw.ReadN(ctx, "Orders", a.config.keys.Orders); err != nil {
 		return err
 	}
-	if RandomChance(a.businessErrProb) {
+	if RandomChance(ActivityRand(ctx, "ValidateCheckout"), a.businessErrProb) {
 		return ErrBusinessLogic
 	}
 	return nil
 }
 
-func (a *AsyncDBSimulator) ValidateAvailability() error {
+func (a *AsyncDBSimulator) ValidateAvailability(ctx context.Context) error {
 	// Get the Item records
-	if err := a.rw.ReadN("Items", a.config.keys.Items); err != nil {
+	if err := a.rw.ReadN(ctx, "Items", a.config.keys.Items); err != nil {
 		return err
 	}
 	// Get SKU Records
-	if err := a.rw.ReadN("StockKeepingUnits", a.config.keys.StockKeepingUnits); err != nil {
+	if err := a.rw.ReadN(ctx, "StockKeepingUnits", a.config.keys.StockKeepingUnits); err != nil {
 		return err
 	}
-	if RandomChance(a.businessErrProb) {
+	if RandomChance(ActivityRand(ctx, "ValidateAvailability"), a.businessErrProb) {
 		return ErrBusinessLogic
 	}
 	return nil
 }
 
-func (a *AsyncDBSimulator) VerifyCustomer() error {
+func (a *AsyncDBSimulator) VerifyCustomer(ctx context.Context) error {
 	// Try to read customer	record
-	if err := a.rw.ReadN("Customers", a.config.keys.Customers); err != nil {
+	if err := a.rw.ReadN(ctx, "Customers", a.config.keys.Customers); err != nil {
 		return err
 	}
 
 	// Read offers, assume one offer per order item
-	if err := a.rw.ReadN("ItemOffers", a.config.keys.ItemOffers); err != nil {
+	if err := a.rw.ReadN(ctx, "ItemOffers", a.config.keys.ItemOffers); err != nil {
 		return err
 	}
-	if RandomChance(a.businessErrProb) {
+	if RandomChance(ActivityRand(ctx, "VerifyCustomer"), a.businessErrProb) {
 		return ErrBusinessLogic
 	}
 	return nil
 }
 
-func (a *AsyncDBSimulator) ValidatePayment() error {
+func (a *AsyncDBSimulator) ValidatePayment(ctx context.Context) error {
 	// Check payments, confirm unconfirmed payments
 	// Assume 1 or 2 payments are unconfirmed
 	var unconfirmedPayments int
 	unconfirmedPayments = min(a.config.PaymentsCnt, 2)
-	if err := a.rw.ReadN("OrderPayments", a.config.keys.OrderPayments[:unconfirmedPayments]); err != nil {
+	if err := a.rw.ReadN(ctx, "OrderPayments", a.config.keys.OrderPayments[:unconfirmedPayments]); err != nil {
 		return err
 	}
-	if err := a.rw.WriteN("OrderPayments", a.config.keys.OrderPayments[unconfirmedPayments:]); err != nil {
+	for i := range unconfirmedPayments {
+		if err := callRemote(ctx, a.providers.PaymentGateway, i); err != nil {
+			return err
+		}
+	}
+	if err := a.rw.WriteN(ctx, "OrderPayments", a.config.keys.OrderPayments[unconfirmedPayments:]); err != nil {
 		return err
 	}
-	if RandomChance(a.businessErrProb) {
+	if RandomChance(ActivityRand(ctx, "ValidatePayment"), a.businessErrProb) {
 		return ErrBusinessLogic
 	}
 	return nil
 }
 
-func (a *AsyncDBSimulator) ValidateProductOption() error {
+func (a *AsyncDBSimulator) ValidateProductOption(ctx context.Context) error {
 	// Assume one ItemOption per OrderItem
-	if err := a.rw.ReadN("ItemOptions", a.config.keys.ItemOptions); err != nil {
+	if err := a.rw.ReadN(ctx, "ItemOptions", a.config.keys.ItemOptions); err != nil {
 		return err
 	}
-	if RandomChance(a.businessErrProb) {
+	if RandomChance(ActivityRand(ctx, "ValidateProductOption"), a.businessErrProb) {
 		return ErrBusinessLogic
 	}
 	return nil
 }
 
-func (a *AsyncDBSimulator) RecordOffer() error {
-	if err := a.rw.WriteN("CustomerOffersUsage", a.config.keys.CustomerOffersUsage); err != nil {
+func (a *AsyncDBSimulator) RecordOffer(ctx context.Context) error {
+	if err := a.rw.WriteN(ctx, "CustomerOffersUsage", a.config.keys.CustomerOffersUsage); err != nil {
 		return err
 	}
 	return nil
 }
 
-func (a *AsyncDBSimulator) CommitTax() error {
-	if err := a.rw.ReadN("Items", a.config.keys.Items); err != nil {
+func (a *AsyncDBSimulator) CommitTax(ctx context.Context) error {
+	if err := a.rw.ReadN(ctx, "Items", a.config.keys.Items); err != nil {
+		return err
+	}
+	if err := callRemote(ctx, a.providers.TaxProvider, 0); err != nil {
 		return err
 	}
-	if err := a.rw.WriteN("OrderTaxes", a.config.keys.OrderTaxes); err != nil {
+	if err := a.rw.WriteN(ctx, "OrderTaxes", a.config.keys.OrderTaxes); err != nil {
 		return err
 	}
 	return nil
 }
 
-func (a *AsyncDBSimulator) DecrementInventory() error {
+func (a *AsyncDBSimulator) DecrementInventory(ctx context.Context) error {
 	// TODO: These reads and writes should hit the same keys as other activities
-	if err := a.rw.ReadN("Items", a.config.keys.Items); err != nil {
+	if err := a.rw.ReadN(ctx, "Items", a.config.keys.Items); err != nil {
 		return err
 	}
-	if err := a.rw.WriteN("StockKeepingUnits", a.config.keys.StockKeepingUnits); err != nil {
+	if err := a.rw.WriteN(ctx, "StockKeepingUnits", a.config.keys.StockKeepingUnits); err != nil {
 		return err
 	}
 	return nil
 }
 
-func (a *AsyncDBSimulator) CompleteOrder() error {
+func (a *AsyncDBSimulator) CompleteOrder(ctx context.Context) error {
 	// TODO: In general, I should initialize access keys before I start the simulation for better lock integrity
-	if err := a.rw.WriteN("Orders", a.config.keys.Orders); err != nil {
+	if err := a.rw.WriteN(ctx, "Orders", a.config.keys.Orders); err != nil {
 		return err
 	}
 	return nil
 }
 
-func NewAsyncDBSimulator(rw TableReadWriteSimulator, l *log.Logger, keys int, bErrProb int) *AsyncDBSimulator {
-	config := RandomConfig()
-	config.SetAccessKeys(keys)
+// NewAsyncDBSimulator creates a simulator of one order, whose shape and access keys are drawn from r.
+// The keys of each table follow its distribution in keys. The remote calls of the activities are made
+// inside the transaction, so the transaction holds its locks while it waits for the providers.
+func NewAsyncDBSimulator(rw TableReadWriteSimulator, l *log.Logger, r *rand.Rand, keys *KeyAccess, bErrProb int, providers Providers) *AsyncDBSimulator {
+	config := RandomConfig(r)
+	config.SetAccessKeys(r, keys)
 	return &AsyncDBSimulator{
 		rw:              rw,
 		l:               l,
 		config:          config,
-		keys:            keys,
 		businessErrProb: bErrProb,
+		providers:       providers,
 	}
 }
+
+// The compensations undo the writes of the operation activities, e.g. when a later step of a saga fails.
+// They take the value of their order (see WithCompensation) out of the keys the activity wrote.
+
+func (a *AsyncDBSimulator) CompensateRecordOffer(ctx context.Context) error {
+	return a.rw.WriteN(ctx, "CustomerOffersUsage", a.config.keys.CustomerOffersUsage)
+}
+
+func (a *AsyncDBSimulator) CompensateCommitTax(ctx context.Context) error {
+	return a.rw.WriteN(ctx, "OrderTaxes", a.config.keys.OrderTaxes)
+}
+
+func (a *AsyncDBSimulator) CompensateDecrementInventory(ctx context.Context) error {
+	return a.rw.WriteN(ctx, "StockKeepingUnits", a.config.keys.StockKeepingUnits)
+}
+
+func (a *AsyncDBSimulator) CompensateCompleteOrder(ctx context.Context) error {
+	return a.rw.WriteN(ctx, "Orders", a.config.keys.Orders)
+}
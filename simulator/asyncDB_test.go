@@ -0,0 +1,99 @@
+package simulator
+
+import (
+	"context"
+	"github.com/Volume999/AsyncDB/asyncdb"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newSimulatedItemsDB(t *testing.T, accessTimeMs int) *asyncdb.AsyncDB {
+	db := asyncdb.NewAsyncDB(asyncdb.NewTransactionManager(), asyncdb.NewLockManager(), asyncdb.NewStringHasher(), asyncdb.WithExplicitTxn())
+	ctx, _ := db.Connect()
+	if err := db.CreateTable(ctx, asyncdb.NewSimulatedTable("Items", accessTimeMs)); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestFanOutLimits(t *testing.T) {
+	db := newSimulatedItemsDB(t, 5)
+	limiter := NewFanOutLimiter(3)
+	const transactions = 4
+	wg := sync.WaitGroup{}
+	for i := range transactions {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			conn, _ := db.Connect()
+			if err := db.BeginTransaction(conn); err != nil {
+				t.Error(err)
+				return
+			}
+			rw := NewConcTableReadWriteSimulator(db, conn, WithFanOut(2), WithGlobalFanOut(limiter))
+			keys := make([]int, 10)
+			for k := range keys {
+				keys[k] = i*len(keys) + k
+			}
+			if err := rw.ReadN(context.Background(), "Items", keys); err != nil {
+				t.Error(err)
+			}
+			_ = db.CommitTransaction(conn)
+		}()
+	}
+	wg.Wait()
+	// Every transaction may have one request in flight beyond the global limit
+	if peak := limiter.Peak(); peak > 3+transactions || peak < 3 {
+		t.Errorf("peak of %d requests in flight, want between 3 and %d", peak, 3+transactions)
+	}
+
+	conn, _ := db.Connect()
+	_ = db.BeginTransaction(conn)
+	start := time.Now()
+	if err := NewConcTableReadWriteSimulator(db, conn, WithFanOut(1)).ReadN(context.Background(), "Items", []int{1, 2, 3, 4}); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 4*5*time.Millisecond {
+		t.Errorf("4 reads one at a time took %v", elapsed)
+	}
+}
+
+func TestSagaWriteValues(t *testing.T) {
+	ctx := context.Background()
+	write := func(value, old string) string { return WriteValue(WithSagaWrite(ctx, value), old) }
+	compensate := func(value, old string) string { return WriteValue(WithCompensation(ctx, value), old) }
+
+	// Order a writes, b writes over it, then a and b are compensated in the order they wrote
+	a := write("a", InitialValue)
+	b := write("b", a)
+	if TopValue(b) != "b" || write("b", b) != b {
+		t.Errorf("b wrote %q, want b on top once", b)
+	}
+	afterA := compensate("a", b)
+	if TopValue(afterA) != "b" {
+		t.Errorf("compensating a exposed %q, want b's write to stay", TopValue(afterA))
+	}
+	if got := compensate("b", afterA); got != InitialValue {
+		t.Errorf("compensating a and b left %q, want %q", got, InitialValue)
+	}
+	if got := compensate("a", InitialValue); got != InitialValue {
+		t.Errorf("compensating an order that did not write left %q", got)
+	}
+	if got := WriteValue(ctx, b); got != InitialValue {
+		t.Errorf("a plain write put %q, want %q", got, InitialValue)
+	}
+
+	// The oldest values are dropped, a key whose kept values are all compensated holds InitialValue
+	value := InitialValue
+	for i := range maxSagaValues + 1 {
+		value = write(strconv.Itoa(i), value)
+	}
+	for i := range maxSagaValues + 1 {
+		value = compensate(strconv.Itoa(i), value)
+	}
+	if value != InitialValue {
+		t.Errorf("compensating all orders left %q, want %q", value, InitialValue)
+	}
+}
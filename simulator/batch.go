@@ -0,0 +1,201 @@
+package simulator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/Volume999/AsyncDB/asyncdb"
+	"github.com/Volume999/BroadleafSimulation/tracing"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"strconv"
+)
+
+// BatchStore reads and writes a set of keys of a table as one batch
+type BatchStore interface {
+	GetMany(ctx context.Context, table string, keys []int) error
+	PutMany(ctx context.Context, table string, keys []int) error
+}
+
+// BatchTableReadWriteSimulator reads and writes all keys of a table in one batch of its store
+type BatchTableReadWriteSimulator struct {
+	store BatchStore
+}
+
+func NewBatchTableReadWriteSimulator(store BatchStore) *BatchTableReadWriteSimulator {
+	return &BatchTableReadWriteSimulator{store: store}
+}
+
+func (b BatchTableReadWriteSimulator) ReadN(ctx context.Context, table string, keys []int) error {
+	if len(keys) == 0 {
+		return ctx.Err()
+	}
+	end := tracing.Start(ctx, tracing.CatDB, "GetMany "+table)
+	err := b.store.GetMany(ctx, table, keys)
+	end(err)
+	return err
+}
+
+func (b BatchTableReadWriteSimulator) WriteN(ctx context.Context, table string, keys []int) error {
+	if len(keys) == 0 {
+		return ctx.Err()
+	}
+	end := tracing.Start(ctx, tracing.CatDB, "PutMany "+table)
+	err := b.store.PutMany(ctx, table, keys)
+	end(err)
+	return err
+}
+
+// AsyncDBBatch issues all requests of a batch on an AsyncDB connection at once and waits for them together.
+// AsyncDB has no multi-key requests, so every key is still a request of its own that takes its lock in the
+// transaction; the batch only saves the goroutine and the span per key of the concurrent simulator.
+type AsyncDBBatch struct {
+	db  *asyncdb.AsyncDB
+	ctx *asyncdb.ConnectionContext
+}
+
+func NewAsyncDBBatch(db *asyncdb.AsyncDB, ctx *asyncdb.ConnectionContext) *AsyncDBBatch {
+	return &AsyncDBBatch{db: db, ctx: ctx}
+}
+
+func (a AsyncDBBatch) GetMany(ctx context.Context, table string, keys []int) error {
+	waits := make([]func() error, 0, len(keys))
+	for _, key := range keys {
+		res := a.db.Get(a.ctx, table, key)
+		waits = append(waits, func() error {
+			select {
+			case r := <-res:
+				return r.Err
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		})
+	}
+	return awaitAll(ctx, waits)
+}
+
+// PutMany puts all keys at once. Writes that depend on the values of the keys (see ReadsBeforeWrite)
+// Get all keys at once first.
+func (a AsyncDBBatch) PutMany(ctx context.Context, table string, keys []int) error {
+	olds := make([]string, len(keys))
+	if ReadsBeforeWrite(ctx) {
+		waits := make([]func() error, 0, len(keys))
+		for i, key := range keys {
+			res := a.db.Get(a.ctx, table, key)
+			waits = append(waits, func() error {
+				select {
+				case r := <-res:
+					olds[i], _ = r.Data.(string)
+					return r.Err
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			})
+		}
+		if err := awaitAll(ctx, waits); err != nil {
+			return err
+		}
+	}
+	waits := make([]func() error, 0, len(keys))
+	for i, key := range keys {
+		res := a.db.Put(a.ctx, table, key, WriteValue(ctx, olds[i]))
+		waits = append(waits, func() error {
+			select {
+			case r := <-res:
+				return r.Err
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		})
+	}
+	return awaitAll(ctx, waits)
+}
+
+// awaitAll waits for the requests of a batch. Once ctx is done it returns without waiting for the rest,
+// AsyncDB buffers the results, so the requests in flight do not block.
+func awaitAll(ctx context.Context, waits []func() error) error {
+	var batchErr error
+	for _, wait := range waits {
+		if err := wait(); err != nil {
+			batchErr = errors.Join(batchErr, err)
+			if ctx.Err() != nil {
+				return batchErr
+			}
+		}
+	}
+	return batchErr
+}
+
+// PgBatch reads and writes the keys of a batch in Postgres with one statement each, WHERE key = ANY($1).
+// The statements run on a pool of their own, outside AsyncDB: they take no AsyncDB locks, are visible
+// before the transaction commits and are not undone when it rolls back.
+type PgBatch struct {
+	pool *pgxpool.Pool
+}
+
+func NewPgBatch(ctx context.Context, connString string) (*PgBatch, error) {
+	pool, err := pgxpool.New(ctx, connString)
+	if err != nil {
+		return nil, err
+	}
+	return &PgBatch{pool: pool}, nil
+}
+
+func (p *PgBatch) Close() {
+	p.pool.Close()
+}
+
+// pgKeys converts the keys to the VARCHAR keys of the tables, see asyncdb.PgTable, and counts the distinct ones
+func pgKeys(keys []int) ([]string, int) {
+	res := make([]string, len(keys))
+	distinct := make(map[int]struct{}, len(keys))
+	for i, key := range keys {
+		res[i] = strconv.Itoa(key)
+		distinct[key] = struct{}{}
+	}
+	return res, len(distinct)
+}
+
+// GetMany reads the keys with one SELECT and fails with asyncdb.ErrKeyNotFound if some are missing
+func (p *PgBatch) GetMany(ctx context.Context, table string, keys []int) error {
+	keyStrs, distinct := pgKeys(keys)
+	rows, err := p.pool.Query(ctx, fmt.Sprintf("SELECT key, value FROM %s WHERE key = ANY($1)", table), keyStrs)
+	if err != nil {
+		return fmt.Errorf("failed to get values from database: %w", err)
+	}
+	defer rows.Close()
+	found := 0
+	for rows.Next() {
+		var key, value string
+		if err = rows.Scan(&key, &value); err != nil {
+			return fmt.Errorf("failed to get values from database: %w", err)
+		}
+		found++
+	}
+	if err = rows.Err(); err != nil {
+		return fmt.Errorf("failed to get values from database: %w", err)
+	}
+	if found < distinct {
+		return fmt.Errorf("%w - %d of %d keys of %s", asyncdb.ErrKeyNotFound, distinct-found, distinct, table)
+	}
+	return nil
+}
+
+// ErrNonTransactionalSaga is returned by non-transactional batches asked for saga writes, which read
+// and write every key in one transaction
+var ErrNonTransactionalSaga = errors.New("non-transactional batches cannot write sagas")
+
+// PutMany updates the keys with one UPDATE and fails with asyncdb.ErrKeyNotFound if some are missing
+func (p *PgBatch) PutMany(ctx context.Context, table string, keys []int) error {
+	if ReadsBeforeWrite(ctx) {
+		return ErrNonTransactionalSaga
+	}
+	keyStrs, distinct := pgKeys(keys)
+	tag, err := p.pool.Exec(ctx, fmt.Sprintf("UPDATE %s SET value = $2 WHERE key = ANY($1)", table), keyStrs, WriteValue(ctx, ""))
+	if err != nil {
+		return fmt.Errorf("failed to update values in database: %w", err)
+	}
+	if updated := int(tag.RowsAffected()); updated < distinct {
+		return fmt.Errorf("%w - %d of %d keys of %s", asyncdb.ErrKeyNotFound, distinct-updated, distinct, table)
+	}
+	return nil
+}
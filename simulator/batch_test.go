@@ -0,0 +1,60 @@
+package simulator
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+type countingBatchStore struct {
+	gets, puts int
+}
+
+func (c *countingBatchStore) GetMany(context.Context, string, []int) error {
+	c.gets++
+	return nil
+}
+
+func (c *countingBatchStore) PutMany(context.Context, string, []int) error {
+	c.puts++
+	return nil
+}
+
+func TestBatchTableReadWriteSimulator(t *testing.T) {
+	store := &countingBatchStore{}
+	rw := NewBatchTableReadWriteSimulator(store)
+	for _, keys := range [][]int{{1, 2, 3}, nil} {
+		if err := rw.ReadN(context.Background(), "Items", keys); err != nil {
+			t.Fatal(err)
+		}
+		if err := rw.WriteN(context.Background(), "Items", keys); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if store.gets != 1 || store.puts != 1 {
+		t.Errorf("%d GetMany and %d PutMany calls, want one batch each for the non-empty keys", store.gets, store.puts)
+	}
+
+	db := newSimulatedItemsDB(t, 20)
+	conn, _ := db.Connect()
+	if err := db.BeginTransaction(conn); err != nil {
+		t.Fatal(err)
+	}
+	rw = NewBatchTableReadWriteSimulator(NewAsyncDBBatch(db, conn))
+	keys := []int{1, 2, 3, 4, 5, 6, 7, 8}
+	start := time.Now()
+	if err := rw.WriteN(context.Background(), "Items", keys); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > 4*20*time.Millisecond {
+		t.Errorf("batch of %d writes took %v, want the requests to run together", len(keys), elapsed)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Millisecond)
+	defer cancel()
+	if err := rw.ReadN(ctx, "Items", []int{11, 12}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("cancelled batch returned %v", err)
+	}
+	_ = db.RollbackTransaction(conn)
+}
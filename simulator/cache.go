@@ -0,0 +1,94 @@
+package simulator
+
+import (
+	"context"
+	"fmt"
+	"github.com/Volume999/BroadleafSimulation/workload"
+	"slices"
+)
+
+// CachedTables are the tables whose reads a cache serves: the catalog data a checkout reads
+var CachedTables = []string{"Items", "StockKeepingUnits", "ItemOffers", "ItemOptions"}
+
+// CachedTableReadWriteSimulator serves the reads of the cached tables from a cache and reads only the missed
+// keys from the simulator it wraps. Writes invalidate the keys before they are made. A hit takes no AsyncDB
+// lock, and a write that is rolled back still invalidates its keys.
+type CachedTableReadWriteSimulator struct {
+	rw    TableReadWriteSimulator
+	cache *workload.Cache
+}
+
+func NewCachedTableReadWriteSimulator(rw TableReadWriteSimulator, cache *workload.Cache) *CachedTableReadWriteSimulator {
+	return &CachedTableReadWriteSimulator{rw: rw, cache: cache}
+}
+
+func (c *CachedTableReadWriteSimulator) ReadN(ctx context.Context, table string, keys []int) error {
+	if !slices.Contains(CachedTables, table) {
+		return c.rw.ReadN(ctx, table, keys)
+	}
+	var misses []int
+	for _, key := range keys {
+		if !c.cache.Get(workload.CacheKey{Table: table, Key: key}) {
+			misses = append(misses, key)
+		}
+	}
+	if len(misses) == 0 {
+		return ctx.Err()
+	}
+	if err := c.rw.ReadN(ctx, table, misses); err != nil {
+		return err
+	}
+	for _, key := range misses {
+		c.cache.Add(workload.CacheKey{Table: table, Key: key})
+	}
+	return nil
+}
+
+func (c *CachedTableReadWriteSimulator) WriteN(ctx context.Context, table string, keys []int) error {
+	if slices.Contains(CachedTables, table) {
+		for _, key := range keys {
+			c.cache.Invalidate(workload.CacheKey{Table: table, Key: key})
+		}
+	}
+	return c.rw.WriteN(ctx, table, keys)
+}
+
+// CachedDiskAccessSimulator serves the disk reads of the cached tables (see workload.WithTableAccess) from a cache,
+// writes invalidate the key they access. Accesses of other tables, or without a table, go to the disk.
+// The simulators of the disk have no keys, so the key of a row is drawn from the table's distribution with
+// the random stream of the row in the checkout (see ActivityRand): the reads and writes of a row of an order
+// access the same key, and the keys do not depend on the interleaving of the checkouts.
+type CachedDiskAccessSimulator struct {
+	disk  workload.DiskAccessSimulator
+	cache *workload.Cache
+	keys  *KeyAccess
+}
+
+func NewCachedDiskAccessSimulator(disk workload.DiskAccessSimulator, cache *workload.Cache, keys *KeyAccess) *CachedDiskAccessSimulator {
+	return &CachedDiskAccessSimulator{disk: disk, cache: cache, keys: keys}
+}
+
+func (c *CachedDiskAccessSimulator) key(ctx context.Context, access workload.TableAccess) workload.CacheKey {
+	r := ActivityRand(ctx, fmt.Sprintf("cache/%s/%d", access.Table, access.Row))
+	return workload.CacheKey{Table: access.Table, Key: c.keys.For(access.Table).Key(r)}
+}
+
+func (c *CachedDiskAccessSimulator) SimulateDiskAccess(ctx context.Context) error {
+	access, ok := workload.TableAccessFrom(ctx)
+	if !ok || !slices.Contains(CachedTables, access.Table) {
+		return c.disk.SimulateDiskAccess(ctx)
+	}
+	key := c.key(ctx, access)
+	if access.Write {
+		c.cache.Invalidate(key)
+		return c.disk.SimulateDiskAccess(ctx)
+	}
+	if c.cache.Get(key) {
+		return ctx.Err()
+	}
+	if err := c.disk.SimulateDiskAccess(ctx); err != nil {
+		return err
+	}
+	c.cache.Add(key)
+	return nil
+}
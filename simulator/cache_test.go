@@ -0,0 +1,113 @@
+package simulator
+
+import (
+	"context"
+	"github.com/Volume999/BroadleafSimulation/workload"
+	"maps"
+	"slices"
+	"testing"
+)
+
+// recordingReadWriteSimulator records the keys that reach it
+type recordingReadWriteSimulator struct {
+	reads, writes []int
+}
+
+func (r *recordingReadWriteSimulator) ReadN(_ context.Context, _ string, keys []int) error {
+	r.reads = append(r.reads, keys...)
+	return nil
+}
+
+func (r *recordingReadWriteSimulator) WriteN(_ context.Context, _ string, keys []int) error {
+	r.writes = append(r.writes, keys...)
+	return nil
+}
+
+type countingDisk struct {
+	accesses int
+}
+
+func (c *countingDisk) SimulateDiskAccess(context.Context) error {
+	c.accesses++
+	return nil
+}
+
+func TestCachedTableReadWriteSimulator(t *testing.T) {
+	ctx := context.Background()
+	store := &recordingReadWriteSimulator{}
+	rw := NewCachedTableReadWriteSimulator(store, workload.NewCache(100, 0))
+	steps := []func() error{
+		func() error { return rw.ReadN(ctx, "Items", []int{1, 2}) },
+		func() error { return rw.ReadN(ctx, "Items", []int{1, 2, 3}) },
+		func() error { return rw.WriteN(ctx, "Items", []int{2}) },
+		func() error { return rw.ReadN(ctx, "Items", []int{1, 2}) },
+		// Orders are not cached
+		func() error { return rw.ReadN(ctx, "Orders", []int{1}) },
+		func() error { return rw.ReadN(ctx, "Orders", []int{1}) },
+	}
+	for _, step := range steps {
+		if err := step(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if want := []int{1, 2, 3, 2, 1, 1}; !slices.Equal(store.reads, want) {
+		t.Errorf("Reads %v reached the tables, want %v", store.reads, want)
+	}
+	if !slices.Equal(store.writes, []int{2}) {
+		t.Errorf("Writes %v reached the tables, want [2]", store.writes)
+	}
+
+	disk := &countingDisk{}
+	cache := workload.NewCache(100, 0)
+	cached := NewCachedDiskAccessSimulator(disk, cache, UniformKeyAccess(1))
+	for _, access := range []context.Context{
+		workload.WithTableAccess(ctx, "Items", 0, false),
+		workload.WithTableAccess(ctx, "Items", 0, false),
+		workload.WithTableAccess(ctx, "Items", 0, true),
+		workload.WithTableAccess(ctx, "Items", 0, false),
+		workload.WithTableAccess(ctx, "Orders", 0, false),
+		ctx,
+	} {
+		if err := cached.SimulateDiskAccess(access); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if disk.accesses != 5 || cache.Stats().Hits != 1 {
+		t.Errorf("%d accesses reached the disk with cache %v, want 5 and one hit", disk.accesses, cache.Stats())
+	}
+}
+
+func TestCachedDiskKeysAreReproducible(t *testing.T) {
+	// The rows of two checkouts with the same seeds access the same keys, in whatever order they run
+	keysOf := func(seeds []uint64) map[workload.CacheKey]bool {
+		cached := NewCachedDiskAccessSimulator(&countingDisk{}, workload.NewCache(1000, 0), UniformKeyAccess(1000000))
+		keys := make(map[workload.CacheKey]bool)
+		for _, seed := range seeds {
+			for row := range 10 {
+				keys[cached.key(WithSeed(context.Background(), seed), workload.TableAccess{Table: "Items", Row: row})] = true
+			}
+		}
+		return keys
+	}
+	if a, b := keysOf([]uint64{1, 2}), keysOf([]uint64{2, 1}); !maps.Equal(a, b) || len(a) != 20 {
+		t.Errorf("checkouts accessed %d and %d keys, want the same 20", len(a), len(b))
+	}
+
+	// A write of a row invalidates the key its read cached
+	disk := &countingDisk{}
+	cache := workload.NewCache(100, 0)
+	cached := NewCachedDiskAccessSimulator(disk, cache, UniformKeyAccess(1000000))
+	ctx := WithSeed(context.Background(), 1)
+	for _, access := range []context.Context{
+		workload.WithTableAccess(ctx, "Items", 3, false),
+		workload.WithTableAccess(ctx, "Items", 3, false),
+		workload.WithTableAccess(ctx, "Items", 3, true),
+	} {
+		if err := cached.SimulateDiskAccess(access); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if s := cache.Stats(); s.Hits != 1 || s.Invalidated != 1 {
+		t.Errorf("cache %v, want one hit and one invalidated key", s)
+	}
+}
@@ -1,6 +1,6 @@
 package simulator
 
-import "math/rand"
+import "math/rand/v2"
 
 const (
 	MaxOrderItems    = 20
@@ -9,6 +9,15 @@ const (
 	DefaultKeys      = 10000
 )
 
+// CPU work of the activities in microseconds, see workload.SimulateCpuLoad. The original simulators spun
+// 100, 1000 and 10000 empty loop iterations, the costs are those counts divided by 10, so they keep
+// their 1:10:100 ratios.
+const (
+	cpuLightUs  = 10
+	cpuMediumUs = 100
+	cpuHeavyUs  = 1000
+)
+
 type Config struct {
 	OrderItemsCnt    int
 	SKUItemsCnt      int
@@ -38,37 +47,35 @@ func NewConfig(orderItemsCnt, skuItemsCnt, appliedOffersCnt, paymentsCnt int) *C
 	}
 }
 
-func randomKeys(n int, keys int) []int {
+func randomKeys(r *rand.Rand, n int, dist KeyDistribution) []int {
 	accessKeys := make([]int, n)
 	for i := range n {
-		accessKeys[i] = rand.Intn(keys) + 1
+		accessKeys[i] = dist.Key(r)
 	}
 	return accessKeys
 }
 
-func RandomConfig() *Config {
+func RandomConfig(r *rand.Rand) *Config {
 	return &Config{
-		OrderItemsCnt:    rand.Intn(MaxOrderItems) + 1,
-		SKUItemsCnt:      rand.Intn(MaxOrderItems) + 1,
-		AppliedOffersCnt: rand.Intn(MaxAppliedOffers) + 1,
-		PaymentsCnt:      rand.Intn(MaxPayments) + 1,
+		OrderItemsCnt:    r.IntN(MaxOrderItems) + 1,
+		SKUItemsCnt:      r.IntN(MaxOrderItems) + 1,
+		AppliedOffersCnt: r.IntN(MaxAppliedOffers) + 1,
+		PaymentsCnt:      r.IntN(MaxPayments) + 1,
 	}
 }
 
-func (c *Config) SetAccessKeys(keys int) {
-	if keys < 1 {
-		keys = DefaultKeys
-	}
+// SetAccessKeys draws the keys of every table from the table's distribution in access
+func (c *Config) SetAccessKeys(r *rand.Rand, access *KeyAccess) {
 	accessKeys := TableAccessKeys{
-		Orders:              randomKeys(1, keys),
-		Items:               randomKeys(c.OrderItemsCnt, keys),
-		StockKeepingUnits:   randomKeys(c.OrderItemsCnt, keys),
-		Customers:           randomKeys(1, keys),
-		ItemOffers:          randomKeys(c.OrderItemsCnt, keys),
-		OrderPayments:       randomKeys(c.PaymentsCnt, keys),
-		ItemOptions:         randomKeys(c.OrderItemsCnt, keys),
-		CustomerOffersUsage: randomKeys(c.OrderItemsCnt, keys),
-		OrderTaxes:          randomKeys(1, keys),
+		Orders:              randomKeys(r, 1, access.For("Orders")),
+		Items:               randomKeys(r, c.OrderItemsCnt, access.For("Items")),
+		StockKeepingUnits:   randomKeys(r, c.OrderItemsCnt, access.For("StockKeepingUnits")),
+		Customers:           randomKeys(r, 1, access.For("Customers")),
+		ItemOffers:          randomKeys(r, c.OrderItemsCnt, access.For("ItemOffers")),
+		OrderPayments:       randomKeys(r, c.PaymentsCnt, access.For("OrderPayments")),
+		ItemOptions:         randomKeys(r, c.OrderItemsCnt, access.For("ItemOptions")),
+		CustomerOffersUsage: randomKeys(r, c.OrderItemsCnt, access.For("CustomerOffersUsage")),
+		OrderTaxes:          randomKeys(r, 1, access.For("OrderTaxes")),
 	}
 	c.keys = accessKeys
 }
@@ -0,0 +1,99 @@
+package simulator
+
+import (
+	"context"
+	"fmt"
+	"github.com/Volume999/BroadleafSimulation/workload"
+	"slices"
+)
+
+// activities are the names of the activities of a checkout, faults can target them
+var activities = []string{"ValidateCheckout", "ValidateAvailability", "VerifyCustomer", "ValidatePayment", "ValidateProductOption",
+	"RecordOffer", "CommitTax", "DecrementInventory", "CompleteOrder"}
+
+// ParseFaultPlan parses the faults (see workload.ParseFaultPlan), their targets must be tables or activities
+func ParseFaultPlan(spec string) (workload.FaultPlan, error) {
+	plan, err := workload.ParseFaultPlan(spec)
+	if err != nil {
+		return workload.FaultPlan{}, err
+	}
+	for target := range plan.Targets {
+		if !slices.Contains(accessedTables, target) && !slices.Contains(activities, target) {
+			return workload.FaultPlan{}, fmt.Errorf("unknown table or activity %q in faults", target)
+		}
+	}
+	return plan, nil
+}
+
+// FaultyTableReadWriteSimulator injects faults into the table reads and writes of another simulator.
+// A fault applies to a whole ReadN or WriteN call, the faults of the table or of the activity
+// in the context of the call apply.
+type FaultyTableReadWriteSimulator struct {
+	rw     TableReadWriteSimulator
+	faults *workload.FaultInjector
+}
+
+func NewFaultyTableReadWriteSimulator(rw TableReadWriteSimulator, faults *workload.FaultInjector) *FaultyTableReadWriteSimulator {
+	return &FaultyTableReadWriteSimulator{rw: rw, faults: faults}
+}
+
+func (f *FaultyTableReadWriteSimulator) ReadN(ctx context.Context, table string, keys []int) error {
+	if err := f.faults.Inject(ctx, table); err != nil {
+		return err
+	}
+	return f.rw.ReadN(ctx, table, keys)
+}
+
+func (f *FaultyTableReadWriteSimulator) WriteN(ctx context.Context, table string, keys []int) error {
+	if err := f.faults.Inject(ctx, table); err != nil {
+		return err
+	}
+	return f.rw.WriteN(ctx, table, keys)
+}
+
+// NamedActivities attaches the name of every activity of the simulator it decorates to the context
+// of the activity (see workload.WithActivity), so faults can target the accesses of an activity,
+// and the faults and disk latencies of its accesses are drawn from streams of the activity
+type NamedActivities struct {
+	simulator Simulator
+}
+
+func NewNamedActivities(simulator Simulator) *NamedActivities {
+	return &NamedActivities{simulator: simulator}
+}
+
+func (n *NamedActivities) ValidateCheckout(ctx context.Context) error {
+	return n.simulator.ValidateCheckout(workload.WithActivity(ctx, "ValidateCheckout"))
+}
+
+func (n *NamedActivities) ValidateAvailability(ctx context.Context) error {
+	return n.simulator.ValidateAvailability(workload.WithActivity(ctx, "ValidateAvailability"))
+}
+
+func (n *NamedActivities) VerifyCustomer(ctx context.Context) error {
+	return n.simulator.VerifyCustomer(workload.WithActivity(ctx, "VerifyCustomer"))
+}
+
+func (n *NamedActivities) ValidatePayment(ctx context.Context) error {
+	return n.simulator.ValidatePayment(workload.WithActivity(ctx, "ValidatePayment"))
+}
+
+func (n *NamedActivities) ValidateProductOption(ctx context.Context) error {
+	return n.simulator.ValidateProductOption(workload.WithActivity(ctx, "ValidateProductOption"))
+}
+
+func (n *NamedActivities) RecordOffer(ctx context.Context) error {
+	return n.simulator.RecordOffer(workload.WithActivity(ctx, "RecordOffer"))
+}
+
+func (n *NamedActivities) CommitTax(ctx context.Context) error {
+	return n.simulator.CommitTax(workload.WithActivity(ctx, "CommitTax"))
+}
+
+func (n *NamedActivities) DecrementInventory(ctx context.Context) error {
+	return n.simulator.DecrementInventory(workload.WithActivity(ctx, "DecrementInventory"))
+}
+
+func (n *NamedActivities) CompleteOrder(ctx context.Context) error {
+	return n.simulator.CompleteOrder(workload.WithActivity(ctx, "CompleteOrder"))
+}
@@ -0,0 +1,93 @@
+package simulator
+
+import (
+	"context"
+	"errors"
+	"github.com/Volume999/BroadleafSimulation/workload"
+	"sync"
+	"testing"
+)
+
+// failingActivity reads a table with the context of the activity
+type failingActivity struct {
+	Simulator
+	rw TableReadWriteSimulator
+}
+
+func (f failingActivity) ValidatePayment(ctx context.Context) error {
+	return f.rw.ReadN(ctx, "OrderPayments", []int{1})
+}
+
+func (f failingActivity) CommitTax(ctx context.Context) error {
+	return f.rw.ReadN(ctx, "OrderTaxes", []int{1})
+}
+
+func TestFaultyTableReadWriteSimulator(t *testing.T) {
+	if _, err := ParseFaultPlan("Payments=error:1"); err == nil {
+		t.Error("Faults of an unknown table parsed")
+	}
+	plan, err := ParseFaultPlan("ValidatePayment=error:1,OrderTaxes=error:1,CommitTax=error:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	faults := workload.NewFaultInjector(plan, ActivityRand)
+	store := &countingBatchStore{}
+	s := NewNamedActivities(failingActivity{rw: NewFaultyTableReadWriteSimulator(NewBatchTableReadWriteSimulator(store), faults)})
+	ctx := context.Background()
+	if err = s.ValidatePayment(ctx); !errors.Is(err, workload.ErrInjected) {
+		t.Errorf("ValidatePayment = %v, want the injected error of the activity", err)
+	}
+	if err = s.CommitTax(ctx); !errors.Is(err, workload.ErrInjected) {
+		t.Errorf("CommitTax = %v, want the injected error of the table", err)
+	}
+	if store.gets != 0 {
+		t.Errorf("%d reads reached the store, want none", store.gets)
+	}
+}
+
+func TestFaultsFollowTheCheckout(t *testing.T) {
+	plan, err := ParseFaultPlan("error:0.3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	const checkouts = 100
+	// run returns which activities of the checkouts failed, the checkouts run concurrently or one by one in reverse
+	run := func(concurrent bool) [checkouts][2]bool {
+		faults := workload.NewFaultInjector(plan, ActivityRand)
+		var failed [checkouts][2]bool
+		checkout := func(i int) {
+			s := NewNamedActivities(failingActivity{rw: NewFaultyTableReadWriteSimulator(NewBatchTableReadWriteSimulator(&countingBatchStore{}), faults)})
+			ctx := WithSeed(context.Background(), DeriveSeed(1, uint64(i)))
+			failed[i] = [2]bool{s.ValidatePayment(ctx) != nil, s.CommitTax(ctx) != nil}
+		}
+		wg := sync.WaitGroup{}
+		for i := checkouts - 1; i >= 0; i-- {
+			if !concurrent {
+				checkout(i)
+				continue
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				checkout(i)
+			}()
+		}
+		wg.Wait()
+		return failed
+	}
+	first, second := run(true), run(false)
+	injected := 0
+	for i := range first {
+		if first[i] != second[i] {
+			t.Errorf("checkout %d failed %v, then %v with the same seed", i, first[i], second[i])
+		}
+		for _, f := range first[i] {
+			if f {
+				injected++
+			}
+		}
+	}
+	if injected == 0 || injected == 2*checkouts {
+		t.Errorf("%d of %d accesses failed, want about 30%%", injected, 2*checkouts)
+	}
+}
@@ -0,0 +1,267 @@
+package simulator
+
+import (
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	UniformKeys    = "uniform"
+	ZipfKeys       = "zipf"
+	HotspotKeys    = "hotspot"
+	LatestKeys     = "latest"
+	SequentialKeys = "sequential"
+)
+
+// KeyDistribution draws the keys an order accesses in a table, in [1, keys]
+type KeyDistribution interface {
+	Key(r *rand.Rand) int
+	String() string
+}
+
+// Uniform accesses every key with the same probability
+type Uniform struct {
+	keys int
+}
+
+func NewUniform(keys int) *Uniform {
+	return &Uniform{keys: keys}
+}
+
+func (u *Uniform) Key(r *rand.Rand) int {
+	return r.IntN(u.keys) + 1
+}
+
+func (u *Uniform) String() string {
+	return UniformKeys
+}
+
+// Zipf accesses the key of rank k with probability proportional to 1/k^s, key 1 being the most popular.
+// Unlike rand.Zipf, s may be below 1 (YCSB uses 0.99).
+type Zipf struct {
+	s   float64
+	cdf []float64
+}
+
+var zipfCDFs sync.Map // zipfParams -> []float64
+
+type zipfParams struct {
+	keys int
+	s    float64
+}
+
+func NewZipf(keys int, s float64) *Zipf {
+	params := zipfParams{keys: keys, s: s}
+	if cdf, ok := zipfCDFs.Load(params); ok {
+		return &Zipf{s: s, cdf: cdf.([]float64)}
+	}
+	cdf := make([]float64, keys)
+	sum := 0.0
+	for k := range keys {
+		sum += 1 / math.Pow(float64(k+1), s)
+		cdf[k] = sum
+	}
+	for k := range cdf {
+		cdf[k] /= sum
+	}
+	cached, _ := zipfCDFs.LoadOrStore(params, cdf)
+	return &Zipf{s: s, cdf: cached.([]float64)}
+}
+
+func (z *Zipf) Key(r *rand.Rand) int {
+	u := r.Float64()
+	return min(sort.SearchFloat64s(z.cdf, u), len(z.cdf)-1) + 1
+}
+
+func (z *Zipf) String() string {
+	return fmt.Sprintf("%s:%v", ZipfKeys, z.s)
+}
+
+// Latest is Zipf-distributed over the newest keys, key `keys` being the most popular
+type Latest struct {
+	zipf *Zipf
+}
+
+func NewLatest(keys int, s float64) *Latest {
+	return &Latest{zipf: NewZipf(keys, s)}
+}
+
+func (l *Latest) Key(r *rand.Rand) int {
+	return len(l.zipf.cdf) - l.zipf.Key(r) + 1
+}
+
+func (l *Latest) String() string {
+	return fmt.Sprintf("%s:%v", LatestKeys, l.zipf.s)
+}
+
+// Hotspot sends hotAccessPct percent of the accesses to the first hotKeysPct percent of the keys,
+// the rest of the accesses are uniform over the remaining keys
+type Hotspot struct {
+	keys         int
+	hotKeys      int
+	hotAccessPct int
+	hotKeysPct   int
+}
+
+func NewHotspot(keys int, hotAccessPct int, hotKeysPct int) *Hotspot {
+	hotKeys := min(max(keys*hotKeysPct/100, 1), keys)
+	return &Hotspot{keys: keys, hotKeys: hotKeys, hotAccessPct: hotAccessPct, hotKeysPct: hotKeysPct}
+}
+
+func (h *Hotspot) Key(r *rand.Rand) int {
+	if h.hotKeys == h.keys || RandomChance(r, h.hotAccessPct) {
+		return r.IntN(h.hotKeys) + 1
+	}
+	return h.hotKeys + r.IntN(h.keys-h.hotKeys) + 1
+}
+
+func (h *Hotspot) String() string {
+	return fmt.Sprintf("%s:%d:%d", HotspotKeys, h.hotAccessPct, h.hotKeysPct)
+}
+
+// Sequential accesses the keys in order, wrapping around. The position is shared by all orders,
+// so the keys depend on the order in which checkouts run and are not reproducible with a seed.
+type Sequential struct {
+	keys int
+	next atomic.Uint64
+}
+
+func NewSequential(keys int) *Sequential {
+	return &Sequential{keys: keys}
+}
+
+func (s *Sequential) Key(_ *rand.Rand) int {
+	return int((s.next.Add(1)-1)%uint64(s.keys)) + 1
+}
+
+func (s *Sequential) String() string {
+	return SequentialKeys
+}
+
+// ParseKeyDistribution parses a distribution of keys in [1, keys]:
+// uniform, zipf:<s>, latest:<s>, hotspot:<access%>:<keys%> or sequential
+func ParseKeyDistribution(spec string, keys int) (KeyDistribution, error) {
+	if keys < 1 {
+		keys = DefaultKeys
+	}
+	kind, args, _ := strings.Cut(spec, ":")
+	var params []string
+	if args != "" {
+		params = strings.Split(args, ":")
+	}
+	wantParams := func(n int) error {
+		if len(params) != n {
+			return fmt.Errorf("key distribution %q takes %d parameter(s), got %q", kind, n, spec)
+		}
+		return nil
+	}
+	switch kind {
+	case UniformKeys, SequentialKeys:
+		if err := wantParams(0); err != nil {
+			return nil, err
+		}
+		if kind == UniformKeys {
+			return NewUniform(keys), nil
+		}
+		return NewSequential(keys), nil
+	case ZipfKeys, LatestKeys:
+		if err := wantParams(1); err != nil {
+			return nil, err
+		}
+		s, err := strconv.ParseFloat(params[0], 64)
+		if err != nil || s < 0 {
+			return nil, fmt.Errorf("invalid skew in key distribution %q", spec)
+		}
+		if kind == ZipfKeys {
+			return NewZipf(keys, s), nil
+		}
+		return NewLatest(keys, s), nil
+	case HotspotKeys:
+		if err := wantParams(2); err != nil {
+			return nil, err
+		}
+		accessPct, err1 := strconv.Atoi(params[0])
+		keysPct, err2 := strconv.Atoi(params[1])
+		if err1 != nil || err2 != nil || accessPct < 0 || accessPct > 100 || keysPct <= 0 || keysPct > 100 {
+			return nil, fmt.Errorf("invalid percentages in key distribution %q", spec)
+		}
+		return NewHotspot(keys, accessPct, keysPct), nil
+	}
+	return nil, fmt.Errorf("unknown key distribution %q", kind)
+}
+
+// accessedTables are the tables whose keys an order draws in Config.SetAccessKeys
+var accessedTables = []string{"Orders", "Items", "StockKeepingUnits", "Customers", "ItemOffers", "OrderPayments", "ItemOptions", "CustomerOffersUsage", "OrderTaxes"}
+
+// KeyAccess selects the key distribution of each table
+type KeyAccess struct {
+	Default KeyDistribution
+	Tables  map[string]KeyDistribution
+}
+
+// UniformKeyAccess accesses the keys of all tables uniformly
+func UniformKeyAccess(keys int) *KeyAccess {
+	if keys < 1 {
+		keys = DefaultKeys
+	}
+	return &KeyAccess{Default: NewUniform(keys)}
+}
+
+// ParseKeyAccess parses a comma-separated list of key distributions. An entry without a table
+// sets the default distribution, <Table>=<distribution> overrides it for one table, e.g.
+// "zipf:0.99,StockKeepingUnits=hotspot:90:10". The default distribution is uniform unless set.
+func ParseKeyAccess(spec string, keys int) (*KeyAccess, error) {
+	access := UniformKeyAccess(keys)
+	if spec == "" {
+		return access, nil
+	}
+	for _, entry := range strings.Split(spec, ",") {
+		table, distSpec, found := strings.Cut(strings.TrimSpace(entry), "=")
+		if !found {
+			table, distSpec = "", table
+		}
+		dist, err := ParseKeyDistribution(distSpec, keys)
+		if err != nil {
+			return nil, err
+		}
+		if table == "" {
+			access.Default = dist
+			continue
+		}
+		if !slices.Contains(accessedTables, table) {
+			return nil, fmt.Errorf("unknown table %q in key distributions", table)
+		}
+		if access.Tables == nil {
+			access.Tables = make(map[string]KeyDistribution)
+		}
+		access.Tables[table] = dist
+	}
+	return access, nil
+}
+
+func (k *KeyAccess) For(table string) KeyDistribution {
+	if dist, ok := k.Tables[table]; ok {
+		return dist
+	}
+	return k.Default
+}
+
+func (k *KeyAccess) String() string {
+	tables := make([]string, 0, len(k.Tables))
+	for table := range k.Tables {
+		tables = append(tables, table)
+	}
+	sort.Strings(tables)
+	parts := []string{k.Default.String()}
+	for _, table := range tables {
+		parts = append(parts, table+"="+k.Tables[table].String())
+	}
+	return strings.Join(parts, ",")
+}
@@ -0,0 +1,63 @@
+package simulator
+
+import (
+	"math/rand/v2"
+	"testing"
+)
+
+func TestKeyDistributionsStayInRange(t *testing.T) {
+	r := rand.New(rand.NewPCG(1, 2))
+	for _, spec := range []string{"uniform", "zipf:0.99", "zipf:1.5", "latest:0.99", "hotspot:90:10", "sequential"} {
+		dist, err := ParseKeyDistribution(spec, 100)
+		if err != nil {
+			t.Fatalf("%s: %v", spec, err)
+		}
+		for range 10000 {
+			if k := dist.Key(r); k < 1 || k > 100 {
+				t.Fatalf("%s: key %d out of [1, 100]", spec, k)
+			}
+		}
+	}
+}
+
+func TestSkewedDistributions(t *testing.T) {
+	r := rand.New(rand.NewPCG(1, 2))
+	n := 100000
+	count := func(dist KeyDistribution, hit func(int) bool) float64 {
+		hits := 0
+		for range n {
+			if hit(dist.Key(r)) {
+				hits++
+			}
+		}
+		return float64(hits) / float64(n)
+	}
+	// With s=1 and 1000 keys, key 1 gets 1/H(1000) ~ 13% of the accesses
+	if p := count(NewZipf(1000, 1), func(k int) bool { return k == 1 }); p < 0.12 || p > 0.145 {
+		t.Errorf("zipf: expected ~13%% of accesses on key 1, got %.3f", p)
+	}
+	if p := count(NewLatest(1000, 1), func(k int) bool { return k == 1000 }); p < 0.12 || p > 0.145 {
+		t.Errorf("latest: expected ~13%% of accesses on key 1000, got %.3f", p)
+	}
+	if p := count(NewHotspot(1000, 80, 20), func(k int) bool { return k <= 200 }); p < 0.79 || p > 0.81 {
+		t.Errorf("hotspot: expected 80%% of accesses on the first 200 keys, got %.3f", p)
+	}
+}
+
+func TestParseKeyAccess(t *testing.T) {
+	access, err := ParseKeyAccess("zipf:0.99, StockKeepingUnits=hotspot:90:10", 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := access.For("Items").String(); got != "zipf:0.99" {
+		t.Errorf("expected the default distribution for Items, got %s", got)
+	}
+	if got := access.For("StockKeepingUnits").String(); got != "hotspot:90:10" {
+		t.Errorf("expected hotspot for StockKeepingUnits, got %s", got)
+	}
+	for _, spec := range []string{"zipf", "zipf:x", "hotspot:90", "hotspot:90:0", "gaussian", "Skus=uniform"} {
+		if _, err := ParseKeyAccess(spec, 1000); err == nil {
+			t.Errorf("expected an error for %q", spec)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package simulator
+
+import (
+	"context"
+	"fmt"
+	"github.com/Volume999/BroadleafSimulation/workload"
+)
+
+// Providers are the remote services of the checkout: ValidatePayment authorizes payments with the payment
+// gateway, CommitTax commits the tax with the tax provider. A nil service is not called, so the zero value
+// simulates the checkout without remote calls.
+type Providers struct {
+	PaymentGateway *workload.RemoteService
+	TaxProvider    *workload.RemoteService
+}
+
+// callRemote makes the call-th call of the activity in ctx to the service, if there is one. The call draws
+// from its own stream of the checkout, see ActivityRand.
+func callRemote(ctx context.Context, s *workload.RemoteService, call int) error {
+	if s == nil {
+		return nil
+	}
+	return s.Call(ctx, ActivityRand(ctx, fmt.Sprintf("remote/%s/%d", s.Name(), call)))
+}
+
+// Names of the providers, their spans are named after them
+const (
+	PaymentGateway = "PaymentGateway"
+	TaxProvider    = "TaxProvider"
+)
+
+// ParseProviders creates the providers from their specs (see workload.ParseRemoteConfig), an empty spec
+// means the provider is not called
+func ParseProviders(paymentGateway string, taxProvider string) (Providers, error) {
+	var p Providers
+	for _, provider := range []struct {
+		name string
+		spec string
+		s    **workload.RemoteService
+	}{
+		{PaymentGateway, paymentGateway, &p.PaymentGateway},
+		{TaxProvider, taxProvider, &p.TaxProvider},
+	} {
+		if provider.spec == "" {
+			continue
+		}
+		config, err := workload.ParseRemoteConfig(provider.spec)
+		if err != nil {
+			return Providers{}, fmt.Errorf("%s: %w", provider.name, err)
+		}
+		*provider.s = workload.NewRemoteService(provider.name, config)
+	}
+	return p, nil
+}
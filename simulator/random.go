@@ -0,0 +1,62 @@
+package simulator
+
+import (
+	"context"
+	"hash/fnv"
+	"math/rand/v2"
+)
+
+type seedKey struct{}
+
+// WithSeed attaches the seed of a checkout to ctx. Activities derive their random streams
+// from it, so a checkout with the same seed makes the same random choices (order shape, keys,
+// business errors, lock indices) regardless of how its goroutines are scheduled.
+func WithSeed(ctx context.Context, seed uint64) context.Context {
+	return context.WithValue(ctx, seedKey{}, seed)
+}
+
+func SeedFrom(ctx context.Context) (uint64, bool) {
+	seed, ok := ctx.Value(seedKey{}).(uint64)
+	return seed, ok
+}
+
+// DeriveSeed returns the seed of a sub-stream, e.g. of the i-th checkout of a run
+func DeriveSeed(seed uint64, stream uint64) uint64 {
+	// splitmix64 finalizer
+	z := seed + (stream+1)*0x9e3779b97f4a7c15
+	z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+	z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+	return z ^ (z >> 31)
+}
+
+// NewWorkerRand returns the random stream of a worker of a run with the given seed
+func NewWorkerRand(seed uint64, worker uint64) *rand.Rand {
+	return rand.New(rand.NewPCG(seed, DeriveSeed(seed, worker)))
+}
+
+// NewNamedRand returns the named random stream of a run with the given seed, e.g. of the disk latencies
+func NewNamedRand(seed uint64, name string) *rand.Rand {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(name))
+	return rand.New(rand.NewPCG(seed, h.Sum64()))
+}
+
+// ActivityRand returns a random stream for the named activity of the checkout in ctx.
+// Without a seed in ctx the stream is randomly seeded. The stream must not be shared between goroutines.
+func ActivityRand(ctx context.Context, activity string) *rand.Rand {
+	seed, ok := SeedFrom(ctx)
+	if !ok {
+		return rand.New(rand.NewPCG(rand.Uint64(), rand.Uint64()))
+	}
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(activity))
+	return rand.New(rand.NewPCG(seed, h.Sum64()))
+}
+
+func RandomIntInRange(r *rand.Rand, i int, i2 int) int {
+	return r.IntN(i2-i+1) + i
+}
+
+func RandomChance(r *rand.Rand, prob int) bool {
+	return r.IntN(100) < prob
+}
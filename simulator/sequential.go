@@ -1,90 +1,133 @@
 package simulator
 
 import (
+	"context"
 	"github.com/Volume999/BroadleafSimulation/workload"
-	"math/rand"
 )
 
 type SequentialSimulator struct {
-	config *Config
-	disk   workload.DiskAccessSimulator
+	config    *Config
+	disk      workload.DiskAccessSimulator
+	providers Providers
 }
 
-func NewSequentialSimulator(config *Config, disk workload.DiskAccessSimulator) *SequentialSimulator {
+func NewSequentialSimulator(config *Config, disk workload.DiskAccessSimulator, providers Providers) *SequentialSimulator {
 	return &SequentialSimulator{
-		config: config,
-		disk:   disk,
+		config:    config,
+		disk:      disk,
+		providers: providers,
 	}
 }
 
-func (s *SequentialSimulator) ValidateCheckout() {
+func (s *SequentialSimulator) ValidateCheckout(ctx context.Context) error {
 	// This function was not implemented in the original BroadLeaf use-case
+	return nil
 }
 
-func (s *SequentialSimulator) ValidateAvailability() {
+func (s *SequentialSimulator) ValidateAvailability(ctx context.Context) error {
 	orderItemsCnt := s.config.OrderItemsCnt
-	for range orderItemsCnt {
-		s.disk.SimulateDiskAccess()   // Load to get the item availability
-		workload.SimulateCpuLoad(100) // Merge SKU Items
+	for i := range orderItemsCnt {
+		if err := s.disk.SimulateDiskAccess(workload.WithTableAccess(ctx, "Items", i, false)); err != nil { // Load to get the item availability
+			return err
+		}
+		workload.SimulateCpuLoad(cpuLightUs) // Merge SKU Items
 	}
 	skuItemsCnt := s.config.SKUItemsCnt
-	for range skuItemsCnt {
-		s.disk.SimulateDiskAccess()   // Load to get the SKU availability
-		workload.SimulateCpuLoad(100) // Some operations on SKU Items
+	for i := range skuItemsCnt {
+		if err := s.disk.SimulateDiskAccess(workload.WithTableAccess(ctx, "StockKeepingUnits", i, false)); err != nil { // Load to get the SKU availability
+			return err
+		}
+		workload.SimulateCpuLoad(cpuLightUs) // Some operations on SKU Items
 	}
+	return nil
 }
 
-func (s *SequentialSimulator) VerifyCustomer() {
-	s.disk.SimulateDiskAccess() // Load to get the customer details
-	workload.SimulateCpuLoad(100)
+func (s *SequentialSimulator) VerifyCustomer(ctx context.Context) error {
+	if err := s.disk.SimulateDiskAccess(ctx); err != nil { // Load to get the customer details
+		return err
+	}
+	workload.SimulateCpuLoad(cpuLightUs)
+	r := ActivityRand(ctx, "VerifyCustomer")
 	appliedOffersCnt := s.config.AppliedOffersCnt
 	for range appliedOffersCnt {
-		isLimitedUse := rand.Intn(2) == 0
+		isLimitedUse := r.IntN(2) == 0
 		if isLimitedUse {
-			s.disk.SimulateDiskAccess() // Get uses by customer
-			workload.SimulateCpuLoad(1000)
+			if err := s.disk.SimulateDiskAccess(ctx); err != nil { // Get uses by customer
+				return err
+			}
+			workload.SimulateCpuLoad(cpuMediumUs)
 		}
 	}
+	return nil
 }
 
-func (s *SequentialSimulator) ValidatePayment() {
-	s.disk.SimulateDiskAccess() // Get Order
+func (s *SequentialSimulator) ValidatePayment(ctx context.Context) error {
+	if err := s.disk.SimulateDiskAccess(ctx); err != nil { // Get Order
+		return err
+	}
+	r := ActivityRand(ctx, "ValidatePayment")
 	paymentsCnt := s.config.PaymentsCnt
-	for range paymentsCnt {
-		isActive := rand.Intn(10) < 4
+	for i := range paymentsCnt {
+		isActive := r.IntN(10) < 4
 		if isActive {
-			s.disk.SimulateDiskAccess() // Make new transaction
-			workload.SimulateCpuLoad(10000)
-			s.disk.SimulateDiskAccess()
-			s.disk.SimulateDiskAccess()
+			if err := s.disk.SimulateDiskAccess(ctx); err != nil { // Make new transaction
+				return err
+			}
+			if err := callRemote(ctx, s.providers.PaymentGateway, i); err != nil { // Authorize the payment
+				return err
+			}
+			workload.SimulateCpuLoad(cpuHeavyUs)
+			if err := s.disk.SimulateDiskAccess(ctx); err != nil {
+				return err
+			}
+			if err := s.disk.SimulateDiskAccess(ctx); err != nil {
+				return err
+			}
 		}
 	}
+	return nil
 }
 
-func (s *SequentialSimulator) ValidateProductOption() {
-
+func (s *SequentialSimulator) ValidateProductOption(ctx context.Context) error {
+	return nil
 }
 
-func (s *SequentialSimulator) RecordOffer() {
-	s.disk.SimulateDiskAccess() // Get Order
-	workload.SimulateCpuLoad(10000)
+func (s *SequentialSimulator) RecordOffer(ctx context.Context) error {
+	if err := s.disk.SimulateDiskAccess(ctx); err != nil { // Get Order
+		return err
+	}
+	workload.SimulateCpuLoad(cpuHeavyUs)
+	return nil
 }
 
-func (s *SequentialSimulator) CommitTax() {
-	s.disk.SimulateDiskAccess() // Get Order
-	s.disk.SimulateDiskAccess()
+func (s *SequentialSimulator) CommitTax(ctx context.Context) error {
+	if err := s.disk.SimulateDiskAccess(ctx); err != nil { // Get Order
+		return err
+	}
+	if err := callRemote(ctx, s.providers.TaxProvider, 0); err != nil { // Commit the tax
+		return err
+	}
+	return s.disk.SimulateDiskAccess(ctx)
 }
 
-func (s *SequentialSimulator) DecrementInventory() {
-	s.disk.SimulateDiskAccess()
+func (s *SequentialSimulator) DecrementInventory(ctx context.Context) error {
+	if err := s.disk.SimulateDiskAccess(ctx); err != nil {
+		return err
+	}
 	orderItemsCnt := s.config.OrderItemsCnt
-	for range orderItemsCnt {
-		s.disk.SimulateDiskAccess()    // put Item
-		workload.SimulateCpuLoad(1000) // Merge SKU Items
-		s.disk.SimulateDiskAccess()    // put SKU
+	for i := range orderItemsCnt {
+		if err := s.disk.SimulateDiskAccess(workload.WithTableAccess(ctx, "Items", i, true)); err != nil { // put Item
+			return err
+		}
+		workload.SimulateCpuLoad(cpuMediumUs) // Merge SKU Items
+		// put SKU
+		if err := s.disk.SimulateDiskAccess(workload.WithTableAccess(ctx, "StockKeepingUnits", i, true)); err != nil {
+			return err
+		}
 	}
+	return nil
 }
 
-func (s *SequentialSimulator) CompleteOrder() {
-	s.disk.SimulateDiskAccess()
+func (s *SequentialSimulator) CompleteOrder(ctx context.Context) error {
+	return s.disk.SimulateDiskAccess(ctx)
 }
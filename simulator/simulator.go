@@ -1,13 +1,18 @@
 package simulator
 
+import "context"
+
+// Simulator activities stop doing work once ctx is done. An activity returns
+// ErrBusinessLogic if the checkout must be rolled back, ctx.Err() if it was cancelled,
+// or the error of the underlying disk or table access.
 type Simulator interface {
-	ValidateCheckout()
-	ValidateAvailability()
-	VerifyCustomer()
-	ValidatePayment()
-	ValidateProductOption()
-	RecordOffer()
-	CommitTax()
-	DecrementInventory()
-	CompleteOrder()
+	ValidateCheckout(ctx context.Context) error
+	ValidateAvailability(ctx context.Context) error
+	VerifyCustomer(ctx context.Context) error
+	ValidatePayment(ctx context.Context) error
+	ValidateProductOption(ctx context.Context) error
+	RecordOffer(ctx context.Context) error
+	CommitTax(ctx context.Context) error
+	DecrementInventory(ctx context.Context) error
+	CompleteOrder(ctx context.Context) error
 }
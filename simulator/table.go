@@ -0,0 +1,48 @@
+package simulator
+
+import (
+	"github.com/Volume999/BroadleafSimulation/workload"
+	"time"
+)
+
+// SimulatedTable is a table like asyncdb.SimulatedTable, but every access takes a latency drawn
+// from a distribution instead of a fixed access time. It stores nothing, Get returns "value" for every key.
+// AsyncDB does not pass the context of an access to its tables, so unlike the disk simulators
+// (see workload.WithLatency) the table cannot draw from the streams of the checkout: all accesses
+// of the table draw from the table's shared stream in the order they arrive, and the latency of an access
+// depends on how the checkouts interleave.
+type SimulatedTable struct {
+	name    string
+	latency *workload.LatencySampler
+}
+
+func NewSimulatedTable(name string, latency *workload.LatencySampler) *SimulatedTable {
+	return &SimulatedTable{name: name, latency: latency}
+}
+
+func (s SimulatedTable) Name() string {
+	return s.name
+}
+
+func (s SimulatedTable) simulateWork() {
+	time.Sleep(s.latency.Sample())
+}
+
+func (s SimulatedTable) Get(_ interface{}) (value interface{}, err error) {
+	s.simulateWork()
+	return "value", nil
+}
+
+func (s SimulatedTable) Put(_ interface{}, _ interface{}) error {
+	s.simulateWork()
+	return nil
+}
+
+func (s SimulatedTable) Delete(_ interface{}) error {
+	s.simulateWork()
+	return nil
+}
+
+func (s SimulatedTable) ValidateTypes(_ interface{}, _ interface{}) error {
+	return nil
+}
@@ -0,0 +1,59 @@
+package simulator
+
+import (
+	"context"
+	"github.com/Volume999/BroadleafSimulation/tracing"
+)
+
+// Traced records a span for every activity of the simulator it decorates,
+// if the context of the activity carries a tracer
+type Traced struct {
+	simulator Simulator
+}
+
+func NewTraced(simulator Simulator) *Traced {
+	return &Traced{simulator: simulator}
+}
+
+func (t *Traced) trace(ctx context.Context, activity string, f func(ctx context.Context) error) error {
+	end := tracing.Start(ctx, tracing.CatActivity, activity)
+	err := f(ctx)
+	end(err)
+	return err
+}
+
+func (t *Traced) ValidateCheckout(ctx context.Context) error {
+	return t.trace(ctx, "ValidateCheckout", t.simulator.ValidateCheckout)
+}
+
+func (t *Traced) ValidateAvailability(ctx context.Context) error {
+	return t.trace(ctx, "ValidateAvailability", t.simulator.ValidateAvailability)
+}
+
+func (t *Traced) VerifyCustomer(ctx context.Context) error {
+	return t.trace(ctx, "VerifyCustomer", t.simulator.VerifyCustomer)
+}
+
+func (t *Traced) ValidatePayment(ctx context.Context) error {
+	return t.trace(ctx, "ValidatePayment", t.simulator.ValidatePayment)
+}
+
+func (t *Traced) ValidateProductOption(ctx context.Context) error {
+	return t.trace(ctx, "ValidateProductOption", t.simulator.ValidateProductOption)
+}
+
+func (t *Traced) RecordOffer(ctx context.Context) error {
+	return t.trace(ctx, "RecordOffer", t.simulator.RecordOffer)
+}
+
+func (t *Traced) CommitTax(ctx context.Context) error {
+	return t.trace(ctx, "CommitTax", t.simulator.CommitTax)
+}
+
+func (t *Traced) DecrementInventory(ctx context.Context) error {
+	return t.trace(ctx, "DecrementInventory", t.simulator.DecrementInventory)
+}
+
+func (t *Traced) CompleteOrder(ctx context.Context) error {
+	return t.trace(ctx, "CompleteOrder", t.simulator.CompleteOrder)
+}
@@ -1,72 +1,193 @@
 package simulator
 
-import "math/rand"
+import (
+	"context"
+	"errors"
+	"github.com/Volume999/BroadleafSimulation/tracing"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var ErrLockTimeout = errors.New("lock wait timeout")
 
 type WithContention struct {
-	simulator Simulator
-	lockCnt   int
-	locks     []chan struct{}
+	simulator   Simulator
+	lockCnt     int
+	locks       []*contentionLock
+	lockTimeout time.Duration
+	// holds is set if the lock timeout is scaled with the hold times, see WithScaledLockTimeout
+	holds *holdTimes
+}
+
+type contentionLock struct {
+	held chan struct{}
+	// queued counts the transactions holding or waiting for the lock
+	queued atomic.Int64
+}
+
+// Scaled lock timeouts, see WithScaledLockTimeout
+const (
+	lockTimeoutHolds     = 4
+	minScaledLockTimeout = 100 * time.Millisecond
+	maxScaledLockTimeout = 5 * time.Second
+)
+
+// holdTimes estimates the median time activities hold their contention locks. Holds that wait for
+// AsyncDB record locks of a deadlocked transaction last until the lock timeout, the median ignores them
+// as long as they are not the majority, so the timeout does not grow with its own deadlocks.
+type holdTimes struct {
+	mu     sync.Mutex
+	median time.Duration
+}
+
+func (h *holdTimes) record(d time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	switch {
+	case h.median == 0:
+		h.median = d
+	case d > h.median:
+		h.median += max(h.median/16, 1)
+	case d < h.median:
+		h.median -= min(h.median/16, h.median-d)
+	}
+}
+
+// timeout is how long a transaction with queued transactions ahead of it may wait for a lock
+func (h *holdTimes) timeout(queued int64) time.Duration {
+	h.mu.Lock()
+	median := h.median
+	h.mu.Unlock()
+	return min(max(minScaledLockTimeout, lockTimeoutHolds*median*time.Duration(queued+1)), maxScaledLockTimeout)
 }
 
-func NewWithContention(simulator Simulator, lockCount int) *WithContention {
-	locks := make([]chan struct{}, lockCount)
+func NewWithContention(simulator Simulator, lockCount int, options ...func(*WithContention)) *WithContention {
+	locks := make([]*contentionLock, lockCount)
 	for i := range locks {
-		locks[i] = make(chan struct{}, 1)
+		locks[i] = &contentionLock{held: make(chan struct{}, 1)}
 	}
-	return &WithContention{
+	s := &WithContention{
 		simulator: simulator,
 		lockCnt:   lockCount,
 		locks:     locks,
 	}
+	for _, option := range options {
+		option(s)
+	}
+	return s
+}
+
+// WithLockTimeout makes activities fail with ErrLockTimeout if they wait for a lock longer than timeout.
+// It is needed when the simulator takes other locks, e.g. AsyncDB record locks, that can form a
+// deadlock with the contention locks: the timed out transaction is aborted and retried.
+func WithLockTimeout(timeout time.Duration) func(*WithContention) {
+	return func(s *WithContention) {
+		s.lockTimeout = timeout
+		s.holds = nil
+	}
+}
+
+// WithScaledLockTimeout is WithLockTimeout with a timeout that scales with the cost of the activities:
+// a transaction may wait lockTimeoutHolds times the median lock hold time for every transaction holding
+// or waiting for the lock before it, between minScaledLockTimeout and maxScaledLockTimeout. A queue that
+// moves is waited for, while one that is stuck behind a deadlock times out.
+func WithScaledLockTimeout() func(*WithContention) {
+	return func(s *WithContention) {
+		s.lockTimeout = 0
+		s.holds = &holdTimes{}
+	}
+}
+
+// Wrap returns a decorator of another simulator that contends for the same locks
+func (s *WithContention) Wrap(simulator Simulator) *WithContention {
+	return &WithContention{
+		simulator:   simulator,
+		lockCnt:     s.lockCnt,
+		locks:       s.locks,
+		lockTimeout: s.lockTimeout,
+		holds:       s.holds,
+	}
 }
 
-func (s *WithContention) acquireLock(lockIndex int) {
-	s.locks[lockIndex] <- struct{}{}
+func (s *WithContention) acquireLock(ctx context.Context, lock *contentionLock) (err error) {
+	end := tracing.Start(ctx, tracing.CatLock, "LockWait")
+	defer func() { end(err) }()
+	queued := lock.queued.Add(1) - 1
+	defer func() {
+		if err != nil {
+			lock.queued.Add(-1)
+		}
+	}()
+	var timeout <-chan time.Time
+	lockTimeout := s.lockTimeout
+	if s.holds != nil {
+		lockTimeout = s.holds.timeout(queued)
+	}
+	if lockTimeout > 0 {
+		timer := time.NewTimer(lockTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case lock.held <- struct{}{}:
+		return nil
+	case <-timeout:
+		return ErrLockTimeout
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
 
-func (s *WithContention) releaseLock(lockIndex int) {
-	<-s.locks[lockIndex]
+func (s *WithContention) releaseLock(lock *contentionLock) {
+	<-lock.held
+	lock.queued.Add(-1)
 }
 
-func (s *WithContention) withLock(f func()) {
-	lockIndex := rand.Intn(s.lockCnt)
-	s.acquireLock(lockIndex)
-	defer s.releaseLock(lockIndex)
-	f()
+func (s *WithContention) withLock(ctx context.Context, activity string, f func(ctx context.Context) error) error {
+	lock := s.locks[ActivityRand(ctx, "contention/"+activity).IntN(s.lockCnt)]
+	if err := s.acquireLock(ctx, lock); err != nil {
+		return err
+	}
+	defer s.releaseLock(lock)
+	if s.holds != nil {
+		defer func(start time.Time) { s.holds.record(time.Since(start)) }(time.Now())
+	}
+	return f(ctx)
 }
 
-func (s *WithContention) ValidateCheckout() {
-	s.withLock(s.simulator.ValidateCheckout)
+func (s *WithContention) ValidateCheckout(ctx context.Context) error {
+	return s.withLock(ctx, "ValidateCheckout", s.simulator.ValidateCheckout)
 }
 
-func (s *WithContention) ValidateAvailability() {
-	s.withLock(s.simulator.ValidateAvailability)
+func (s *WithContention) ValidateAvailability(ctx context.Context) error {
+	return s.withLock(ctx, "ValidateAvailability", s.simulator.ValidateAvailability)
 }
 
-func (s *WithContention) VerifyCustomer() {
-	s.withLock(s.simulator.VerifyCustomer)
+func (s *WithContention) VerifyCustomer(ctx context.Context) error {
+	return s.withLock(ctx, "VerifyCustomer", s.simulator.VerifyCustomer)
 }
 
-func (s *WithContention) ValidatePayment() {
-	s.withLock(s.simulator.ValidatePayment)
+func (s *WithContention) ValidatePayment(ctx context.Context) error {
+	return s.withLock(ctx, "ValidatePayment", s.simulator.ValidatePayment)
 }
 
-func (s *WithContention) ValidateProductOption() {
-	s.withLock(s.simulator.ValidateProductOption)
+func (s *WithContention) ValidateProductOption(ctx context.Context) error {
+	return s.withLock(ctx, "ValidateProductOption", s.simulator.ValidateProductOption)
 }
 
-func (s *WithContention) RecordOffer() {
-	s.withLock(s.simulator.RecordOffer)
+func (s *WithContention) RecordOffer(ctx context.Context) error {
+	return s.withLock(ctx, "RecordOffer", s.simulator.RecordOffer)
 }
 
-func (s *WithContention) CommitTax() {
-	s.withLock(s.simulator.CommitTax)
+func (s *WithContention) CommitTax(ctx context.Context) error {
+	return s.withLock(ctx, "CommitTax", s.simulator.CommitTax)
 }
 
-func (s *WithContention) DecrementInventory() {
-	s.withLock(s.simulator.DecrementInventory)
+func (s *WithContention) DecrementInventory(ctx context.Context) error {
+	return s.withLock(ctx, "DecrementInventory", s.simulator.DecrementInventory)
 }
 
-func (s *WithContention) CompleteOrder() {
-	s.withLock(s.simulator.CompleteOrder)
+func (s *WithContention) CompleteOrder(ctx context.Context) error {
+	return s.withLock(ctx, "CompleteOrder", s.simulator.CompleteOrder)
 }
@@ -0,0 +1,30 @@
+package simulator
+
+import (
+	"testing"
+	"time"
+)
+
+func TestScaledLockTimeout(t *testing.T) {
+	h := &holdTimes{}
+	if got := h.timeout(0); got != minScaledLockTimeout {
+		t.Errorf("timeout without holds is %v, want %v", got, minScaledLockTimeout)
+	}
+	// A third of the holds wait for a deadlocked transaction until the maximal timeout
+	for i := range 300 {
+		d := 50 * time.Millisecond
+		if i%3 == 0 {
+			d = maxScaledLockTimeout
+		}
+		h.record(d)
+	}
+	if got := h.timeout(0); got < 150*time.Millisecond || got > 250*time.Millisecond {
+		t.Errorf("timeout is %v, want about %d holds of 50ms", got, lockTimeoutHolds)
+	}
+	if got, first := h.timeout(3), h.timeout(0); got != 4*first {
+		t.Errorf("timeout behind 3 transactions is %v, want 4 times %v", got, first)
+	}
+	if got := h.timeout(1000); got != maxScaledLockTimeout {
+		t.Errorf("timeout behind 1000 transactions is %v, want %v", got, maxScaledLockTimeout)
+	}
+}
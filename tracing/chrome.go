@@ -0,0 +1,126 @@
+package tracing
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"time"
+)
+
+// traceEvent is an event of the trace-event format,
+// see https://docs.google.com/document/d/1CvAClvFfyA5R-PhYUmn5OOQtYMH4h6I0nSsKchNAySU
+type traceEvent struct {
+	Name     string                 `json:"name"`
+	Category string                 `json:"cat,omitempty"`
+	Phase    string                 `json:"ph"`
+	Ts       float64                `json:"ts"`
+	Dur      float64                `json:"dur"`
+	Pid      int                    `json:"pid"`
+	Tid      int                    `json:"tid"`
+	Args     map[string]interface{} `json:"args,omitempty"`
+}
+
+type traceFile struct {
+	TraceEvents     []traceEvent `json:"traceEvents"`
+	DisplayTimeUnit string       `json:"displayTimeUnit"`
+}
+
+func toUs(d time.Duration) float64 {
+	return float64(d) / float64(time.Microsecond)
+}
+
+// assignLanes places the spans of one workflow on lanes (threads in the trace viewer) so that the spans
+// of a lane are properly nested. Concurrent activities and accesses end up on separate lanes.
+func assignLanes(spans []Span) []int {
+	order := make([]int, len(spans))
+	for i := range order {
+		order[i] = i
+	}
+	// Parents start before their children, and are longer if they start at the same time
+	sort.SliceStable(order, func(a, b int) bool {
+		sa, sb := spans[order[a]], spans[order[b]]
+		if sa.Start != sb.Start {
+			return sa.Start < sb.Start
+		}
+		return sa.End > sb.End
+	})
+	lanes := make([]int, len(spans))
+	// open holds the end times of the spans of each lane that may still contain later spans
+	var open [][]time.Duration
+	for _, i := range order {
+		s := spans[i]
+		lane := -1
+		for l := range open {
+			for len(open[l]) > 0 && open[l][len(open[l])-1] <= s.Start {
+				open[l] = open[l][:len(open[l])-1]
+			}
+			if len(open[l]) == 0 || open[l][len(open[l])-1] >= s.End {
+				lane = l
+				break
+			}
+		}
+		if lane < 0 {
+			lane = len(open)
+			open = append(open, nil)
+		}
+		open[lane] = append(open[lane], s.End)
+		lanes[i] = lane
+	}
+	return lanes
+}
+
+// WriteChromeTrace writes the spans as trace-event JSON. Every workflow is a process,
+// the lanes of its concurrent spans are threads.
+func WriteChromeTrace(w io.Writer, spans []Span) error {
+	byWorkflow := make(map[int][]Span)
+	for _, s := range spans {
+		byWorkflow[s.Workflow] = append(byWorkflow[s.Workflow], s)
+	}
+	workflows := make([]int, 0, len(byWorkflow))
+	for workflow := range byWorkflow {
+		workflows = append(workflows, workflow)
+	}
+	sort.Ints(workflows)
+	events := make([]traceEvent, 0, len(spans)+len(workflows))
+	for _, workflow := range workflows {
+		events = append(events, traceEvent{
+			Name:  "process_name",
+			Phase: "M",
+			Pid:   workflow,
+			Args:  map[string]interface{}{"name": fmt.Sprintf("Workflow #%d", workflow)},
+		})
+		wfSpans := byWorkflow[workflow]
+		lanes := assignLanes(wfSpans)
+		for i, s := range wfSpans {
+			args := map[string]interface{}{"checkout": s.Checkout}
+			if s.Err {
+				args["error"] = true
+			}
+			events = append(events, traceEvent{
+				Name:     s.Name,
+				Category: s.Category,
+				Phase:    "X",
+				Ts:       toUs(s.Start),
+				Dur:      toUs(s.End - s.Start),
+				Pid:      workflow,
+				Tid:      lanes[i],
+				Args:     args,
+			})
+		}
+	}
+	return json.NewEncoder(w).Encode(traceFile{TraceEvents: events, DisplayTimeUnit: "ms"})
+}
+
+func WriteChromeTraceFile(path string, spans []Span) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err = WriteChromeTrace(f, spans); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
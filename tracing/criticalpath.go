@@ -0,0 +1,239 @@
+package tracing
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ActivityStats aggregates the critical-path analysis of one activity over all analyzed checkouts
+type ActivityStats struct {
+	Name string
+	// Checkouts is the number of analyzed checkouts the activity ran in
+	Checkouts int
+	// OnPath is the number of checkouts the activity was on the critical path of
+	OnPath       int
+	MeanDuration time.Duration
+	// MeanSlack is how much longer the activity could have taken on average without delaying the checkout
+	MeanSlack time.Duration
+}
+
+// CriticalPathReport is the critical-path analysis of the checkouts of a trace
+type CriticalPathReport struct {
+	// Checkouts is the number of analyzed checkouts, Skipped the number of failed or rolled back ones
+	Checkouts int
+	Skipped   int
+	// MeanPathLength is the mean sum of the activity durations on the critical path,
+	// MeanLatency the mean duration of the analyzed checkouts
+	MeanPathLength time.Duration
+	MeanLatency    time.Duration
+	Activities     []ActivityStats
+	// Paths counts the checkouts by their critical path, e.g. "ValidatePayment > CommitTax > CompleteOrder"
+	Paths map[string]int
+}
+
+type checkoutSpans struct {
+	checkout   *Span
+	attempts   []Span
+	activities []Span
+}
+
+// CriticalPaths finds the critical path of every successful checkout in spans. deps maps every activity
+// to the activities it waits for in the workflow's schedule. The activity durations are taken from the
+// trace and the checkout is treated as a project: the critical path is the chain of dependent activities
+// with the largest total duration, and the slack of an activity is how much it could be delayed without
+// lengthening that chain. Of retried checkouts, only the last attempt is analyzed.
+func CriticalPaths(spans []Span, deps map[string][]string) *CriticalPathReport {
+	type checkoutKey struct{ workflow, checkout int }
+	checkouts := make(map[checkoutKey]*checkoutSpans)
+	for i, s := range spans {
+		k := checkoutKey{s.Workflow, s.Checkout}
+		c, ok := checkouts[k]
+		if !ok {
+			c = &checkoutSpans{}
+			checkouts[k] = c
+		}
+		switch s.Category {
+		case CatCheckout:
+			c.checkout = &spans[i]
+		case CatAttempt:
+			c.attempts = append(c.attempts, s)
+		case CatActivity:
+			c.activities = append(c.activities, s)
+		}
+	}
+
+	report := &CriticalPathReport{Paths: make(map[string]int)}
+	stats := make(map[string]*ActivityStats)
+	slackSum, durationSum := make(map[string]time.Duration), make(map[string]time.Duration)
+	var pathSum, latencySum time.Duration
+	for _, c := range checkouts {
+		activities := c.lastAttempt()
+		if c.checkout == nil || c.checkout.Err || len(activities) == 0 {
+			report.Skipped++
+			continue
+		}
+		path, slack, length, ok := analyze(activities, deps)
+		if !ok {
+			report.Skipped++
+			continue
+		}
+		report.Checkouts++
+		pathSum += length
+		latencySum += c.checkout.End - c.checkout.Start
+		report.Paths[strings.Join(path, " > ")]++
+		for _, a := range activities {
+			st, ok := stats[a.Name]
+			if !ok {
+				st = &ActivityStats{Name: a.Name}
+				stats[a.Name] = st
+			}
+			st.Checkouts++
+			durationSum[a.Name] += a.End - a.Start
+			slackSum[a.Name] += slack[a.Name]
+		}
+		for _, name := range path {
+			stats[name].OnPath++
+		}
+	}
+	if report.Checkouts == 0 {
+		return report
+	}
+	report.MeanPathLength = pathSum / time.Duration(report.Checkouts)
+	report.MeanLatency = latencySum / time.Duration(report.Checkouts)
+	for name, st := range stats {
+		st.MeanDuration = durationSum[name] / time.Duration(st.Checkouts)
+		st.MeanSlack = slackSum[name] / time.Duration(st.Checkouts)
+		report.Activities = append(report.Activities, *st)
+	}
+	sort.Slice(report.Activities, func(i, j int) bool {
+		a, b := report.Activities[i], report.Activities[j]
+		if a.OnPath != b.OnPath {
+			return a.OnPath > b.OnPath
+		}
+		return a.Name < b.Name
+	})
+	return report
+}
+
+// lastAttempt returns the activities of the last transaction attempt, or all activities if there are no attempts
+func (c *checkoutSpans) lastAttempt() []Span {
+	if len(c.attempts) == 0 {
+		return c.activities
+	}
+	last := c.attempts[0]
+	for _, a := range c.attempts[1:] {
+		if a.Start > last.Start {
+			last = a
+		}
+	}
+	if last.Err {
+		return nil
+	}
+	var activities []Span
+	for _, a := range c.activities {
+		if a.Start >= last.Start && a.End <= last.End {
+			activities = append(activities, a)
+		}
+	}
+	return activities
+}
+
+// analyze runs the critical path method on the activities of one checkout. It returns false if an activity failed.
+func analyze(activities []Span, deps map[string][]string) (path []string, slack map[string]time.Duration, length time.Duration, ok bool) {
+	// An activity starts after its dependencies end, so the start time is a topological order
+	sorted := append([]Span(nil), activities...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Start < sorted[j].Start })
+	index := make(map[string]int, len(sorted))
+	for i, a := range sorted {
+		if a.Err {
+			return nil, nil, 0, false
+		}
+		index[a.Name] = i
+	}
+	earliestStart := make([]time.Duration, len(sorted))
+	earliestEnd := make([]time.Duration, len(sorted))
+	successors := make([][]int, len(sorted))
+	for i, a := range sorted {
+		for _, dep := range deps[a.Name] {
+			if j, ran := index[dep]; ran && j < i {
+				earliestStart[i] = max(earliestStart[i], earliestEnd[j])
+				successors[j] = append(successors[j], i)
+			}
+		}
+		earliestEnd[i] = earliestStart[i] + (a.End - a.Start)
+		length = max(length, earliestEnd[i])
+	}
+	latestEnd := make([]time.Duration, len(sorted))
+	slack = make(map[string]time.Duration, len(sorted))
+	for i := len(sorted) - 1; i >= 0; i-- {
+		latestEnd[i] = length
+		for _, s := range successors[i] {
+			latestEnd[i] = min(latestEnd[i], latestEnd[s]-(sorted[s].End-sorted[s].Start))
+		}
+		slack[sorted[i].Name] = latestEnd[i] - earliestEnd[i]
+	}
+	// Walk back from the last activity to finish through the dependencies that finished last
+	current := -1
+	for i := range sorted {
+		if earliestEnd[i] == length {
+			current = i
+		}
+	}
+	for current >= 0 {
+		path = append(path, sorted[current].Name)
+		next := -1
+		for _, dep := range deps[sorted[current].Name] {
+			if j, ran := index[dep]; ran && j < current && earliestEnd[j] == earliestStart[current] {
+				next = j
+				break
+			}
+		}
+		current = next
+	}
+	for i, j := 0, len(path)-1; i < j; i, j = i+1, j-1 {
+		path[i], path[j] = path[j], path[i]
+	}
+	return path, slack, length, true
+}
+
+func (r *CriticalPathReport) Write(w io.Writer) error {
+	if _, err := fmt.Fprintf(w, "critical path: %d checkouts analyzed, %d skipped, mean path length %v, mean latency %v\n",
+		r.Checkouts, r.Skipped, r.MeanPathLength.Round(time.Microsecond), r.MeanLatency.Round(time.Microsecond)); err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(w, "  %-22s %9s %12s %12s\n", "activity", "on path", "mean dur", "mean slack"); err != nil {
+		return err
+	}
+	for _, a := range r.Activities {
+		onPath := 100 * float64(a.OnPath) / float64(a.Checkouts)
+		if _, err := fmt.Fprintf(w, "  %-22s %8.1f%% %12v %12v\n", a.Name, onPath,
+			a.MeanDuration.Round(time.Microsecond), a.MeanSlack.Round(time.Microsecond)); err != nil {
+			return err
+		}
+	}
+	paths := make([]string, 0, len(r.Paths))
+	for p := range r.Paths {
+		paths = append(paths, p)
+	}
+	sort.Slice(paths, func(i, j int) bool {
+		if r.Paths[paths[i]] != r.Paths[paths[j]] {
+			return r.Paths[paths[i]] > r.Paths[paths[j]]
+		}
+		return paths[i] < paths[j]
+	})
+	if _, err := fmt.Fprintln(w, "  most frequent critical paths:"); err != nil {
+		return err
+	}
+	for i, p := range paths {
+		if i == 5 {
+			break
+		}
+		if _, err := fmt.Fprintf(w, "  %5.1f%% %s\n", 100*float64(r.Paths[p])/float64(r.Checkouts), p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+package tracing
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCriticalPaths(t *testing.T) {
+	ms := time.Millisecond
+	deps := map[string][]string{
+		"C": {"A", "B"},
+	}
+	span := func(category, name string, checkout int, start, end time.Duration) Span {
+		return Span{Category: category, Name: name, Workflow: 1, Checkout: checkout, Start: start, End: end}
+	}
+	spans := []Span{
+		// A is on the critical path, B has 20ms of slack
+		span(CatActivity, "A", 1, 0, 30*ms),
+		span(CatActivity, "B", 1, 0, 10*ms),
+		span(CatActivity, "C", 1, 31*ms, 41*ms),
+		span(CatCheckout, "Checkout", 1, 0, 42*ms),
+		// A failed first attempt, then B is on the critical path
+		span(CatAttempt, "Attempt 1", 2, 50*ms, 60*ms),
+		span(CatActivity, "A", 2, 50*ms, 60*ms),
+		span(CatAttempt, "Attempt 2", 2, 61*ms, 100*ms),
+		span(CatActivity, "A", 2, 61*ms, 65*ms),
+		span(CatActivity, "B", 2, 61*ms, 81*ms),
+		span(CatActivity, "C", 2, 81*ms, 91*ms),
+		span(CatCheckout, "Checkout", 2, 50*ms, 100*ms),
+		// Failed checkouts are skipped
+		span(CatActivity, "A", 3, 0, 10*ms),
+		{Category: CatCheckout, Name: "Checkout", Workflow: 1, Checkout: 3, End: 10 * ms, Err: true},
+	}
+	r := CriticalPaths(spans, deps)
+	if r.Checkouts != 2 || r.Skipped != 1 {
+		t.Fatalf("expected 2 analyzed and 1 skipped checkouts, got %d and %d", r.Checkouts, r.Skipped)
+	}
+	if r.Paths["A > C"] != 1 || r.Paths["B > C"] != 1 {
+		t.Errorf("unexpected paths %v", r.Paths)
+	}
+	if r.MeanPathLength != 35*ms {
+		t.Errorf("expected a mean path length of 35ms, got %v", r.MeanPathLength)
+	}
+	stats := make(map[string]ActivityStats)
+	for _, a := range r.Activities {
+		stats[a.Name] = a
+	}
+	if c := stats["C"]; c.OnPath != 2 || c.MeanSlack != 0 {
+		t.Errorf("C must always be critical, got %+v", c)
+	}
+	// A: 0 slack in the first checkout, 16ms in the second; B: 20ms, then 0
+	if a := stats["A"]; a.OnPath != 1 || a.MeanSlack != 8*ms || a.MeanDuration != 17*ms {
+		t.Errorf("unexpected stats of A: %+v", a)
+	}
+	if b := stats["B"]; b.OnPath != 1 || b.MeanSlack != 10*ms {
+		t.Errorf("unexpected stats of B: %+v", b)
+	}
+	out := &strings.Builder{}
+	if err := r.Write(out); err != nil || !strings.Contains(out.String(), "50.0% A > C") {
+		t.Errorf("unexpected report %q (%v)", out.String(), err)
+	}
+}
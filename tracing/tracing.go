@@ -0,0 +1,127 @@
+// Package tracing records the start and end times of checkouts, activities and individual
+// disk and DB accesses, and exports them as trace-event JSON for chrome://tracing or Perfetto.
+package tracing
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Span categories
+const (
+	CatCheckout = "checkout"
+	CatAttempt  = "attempt"
+	CatActivity = "activity"
+	CatLock     = "lock"
+	CatDisk     = "disk"
+	CatDB       = "db"
+	// CatRemote spans are calls to remote services, e.g. the payment gateway
+	CatRemote = "remote"
+	// CatFault spans are injected faults, e.g. the delay of a latency spike
+	CatFault = "fault"
+	// CatSaga spans are the transactions of saga steps and their compensations
+	CatSaga = "saga"
+)
+
+// Span is one timed operation of a checkout
+type Span struct {
+	Category string
+	Name     string
+	Workflow int
+	Checkout int
+	Start    time.Duration // since the tracer was created
+	End      time.Duration
+	Err      bool
+}
+
+// Tracer collects spans from all checkouts. It is safe for concurrent use.
+type Tracer struct {
+	start      time.Time
+	maxSpans   int
+	categories map[string]bool
+	mu         sync.Mutex
+	spans      []Span
+	dropped    atomic.Int64
+}
+
+// NewTracer creates a tracer that keeps at most maxSpans spans, 0 means no limit.
+// Spans beyond the limit are counted as dropped. If categories are given, only spans of these categories are recorded.
+func NewTracer(maxSpans int, categories ...string) *Tracer {
+	t := &Tracer{start: time.Now(), maxSpans: maxSpans}
+	if len(categories) > 0 {
+		t.categories = make(map[string]bool, len(categories))
+		for _, category := range categories {
+			t.categories[category] = true
+		}
+	}
+	return t
+}
+
+func (t *Tracer) record(s Span) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.maxSpans > 0 && len(t.spans) >= t.maxSpans {
+		t.dropped.Add(1)
+		return
+	}
+	t.spans = append(t.spans, s)
+}
+
+// Spans returns a copy of the recorded spans in the order they ended
+func (t *Tracer) Spans() []Span {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]Span(nil), t.spans...)
+}
+
+func (t *Tracer) Dropped() int {
+	return int(t.dropped.Load())
+}
+
+type tracerKey struct{}
+
+type checkoutKey struct{}
+
+type checkoutID struct {
+	workflow int
+	checkout int
+}
+
+// WithTracer makes the spans started with ctx be recorded by t
+func WithTracer(ctx context.Context, t *Tracer) context.Context {
+	return context.WithValue(ctx, tracerKey{}, t)
+}
+
+func FromContext(ctx context.Context) *Tracer {
+	t, _ := ctx.Value(tracerKey{}).(*Tracer)
+	return t
+}
+
+// WithCheckout tags the spans started with ctx with the workflow and the checkout they belong to
+func WithCheckout(ctx context.Context, workflow int, checkout int) context.Context {
+	return context.WithValue(ctx, checkoutKey{}, checkoutID{workflow: workflow, checkout: checkout})
+}
+
+// Start starts a span and returns the function that ends it with the error of the operation.
+// Without a tracer in ctx, or if the tracer does not record the category, it does nothing.
+func Start(ctx context.Context, category string, name string) func(err error) {
+	t := FromContext(ctx)
+	if t == nil || (t.categories != nil && !t.categories[category]) {
+		return func(error) {}
+	}
+	id, _ := ctx.Value(checkoutKey{}).(checkoutID)
+	start := time.Since(t.start)
+	return func(err error) {
+		t.record(Span{
+			Category: category,
+			Name:     name,
+			Workflow: id.workflow,
+			Checkout: id.checkout,
+			Start:    start,
+			End:      time.Since(t.start),
+			Err:      err != nil,
+		})
+	}
+}
@@ -0,0 +1,68 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestStartWithoutTracerIsNoop(t *testing.T) {
+	end := Start(context.Background(), CatActivity, "RecordOffer")
+	end(nil)
+}
+
+func TestSpansAreTaggedWithCheckout(t *testing.T) {
+	tracer := NewTracer(2)
+	ctx := WithCheckout(WithTracer(context.Background(), tracer), 3, 7)
+	Start(ctx, CatActivity, "RecordOffer")(nil)
+	Start(ctx, CatDisk, "DiskAccess")(errors.New("failed"))
+	Start(ctx, CatDisk, "DiskAccess")(nil)
+	spans := tracer.Spans()
+	if len(spans) != 2 || tracer.Dropped() != 1 {
+		t.Fatalf("expected 2 spans and 1 dropped, got %d and %d", len(spans), tracer.Dropped())
+	}
+	if s := spans[1]; s.Workflow != 3 || s.Checkout != 7 || !s.Err || s.Category != CatDisk {
+		t.Errorf("unexpected span %+v", s)
+	}
+}
+
+func TestAssignLanesNestsSpans(t *testing.T) {
+	ms := time.Millisecond
+	spans := []Span{
+		{Name: "Checkout", Start: 0, End: 100 * ms},
+		{Name: "A", Start: 10 * ms, End: 50 * ms},
+		{Name: "B", Start: 20 * ms, End: 60 * ms}, // overlaps A without nesting
+		{Name: "A1", Start: 15 * ms, End: 40 * ms},
+		{Name: "C", Start: 60 * ms, End: 90 * ms},
+	}
+	lanes := assignLanes(spans)
+	want := []int{0, 0, 1, 0, 0}
+	for i := range spans {
+		if lanes[i] != want[i] {
+			t.Errorf("%s: expected lane %d, got %d", spans[i].Name, want[i], lanes[i])
+		}
+	}
+}
+
+func TestWriteChromeTrace(t *testing.T) {
+	spans := []Span{{Category: CatCheckout, Name: "Checkout", Workflow: 1, Checkout: 1, Start: time.Millisecond, End: 3 * time.Millisecond}}
+	buf := &bytes.Buffer{}
+	if err := WriteChromeTrace(buf, spans); err != nil {
+		t.Fatal(err)
+	}
+	var trace struct {
+		TraceEvents []map[string]interface{} `json:"traceEvents"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &trace); err != nil {
+		t.Fatal(err)
+	}
+	if len(trace.TraceEvents) != 2 {
+		t.Fatalf("expected a process name and a span event, got %v", trace.TraceEvents)
+	}
+	if e := trace.TraceEvents[1]; e["ph"] != "X" || e["ts"] != 1000.0 || e["dur"] != 2000.0 {
+		t.Errorf("unexpected span event %v", e)
+	}
+}
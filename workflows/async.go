@@ -1,48 +1,97 @@
 package workflows
 
 import (
+	"context"
+	"errors"
 	"github.com/Volume999/BroadleafSimulation/simulator"
-	"sync"
 )
 
 type AsyncWorkflow struct {
-	s simulator.Simulator
+	s    simulator.Simulator
+	exec execution
 }
 
-func NewAsyncWorkflow(s simulator.Simulator) *AsyncWorkflow {
-	return &AsyncWorkflow{s}
+// execution holds the options of the workflows that run activities concurrently
+type execution struct {
+	failFast bool
 }
 
-func (w *AsyncWorkflow) Execute() {
-	validationPhase := []func(){
+type ExecutionOption func(*execution)
+
+// FailFast makes the first failed activity cancel its running siblings, together with their pending DB calls
+// and disk accesses, instead of waiting for them to finish. The error of the first failure is returned,
+// the cancellation errors of the siblings are dropped.
+func FailFast() ExecutionOption {
+	return func(e *execution) {
+		e.failFast = true
+	}
+}
+
+func newExecution(options []ExecutionOption) execution {
+	var e execution
+	for _, option := range options {
+		option(&e)
+	}
+	return e
+}
+
+func NewAsyncWorkflow(s simulator.Simulator, options ...ExecutionOption) *AsyncWorkflow {
+	return &AsyncWorkflow{s: s, exec: newExecution(options)}
+}
+
+// runPhase runs the activities concurrently and joins their errors.
+// No more activities are started once ctx is done, or after the first error with fail-fast.
+func (e execution) runPhase(ctx context.Context, phase []func(ctx context.Context) error) error {
+	parent, cancel := ctx, context.CancelFunc(func() {})
+	if e.failFast {
+		ctx, cancel = context.WithCancel(ctx)
+	}
+	defer cancel()
+	errChan := make(chan error, len(phase))
+	started := 0
+	for _, activity := range phase {
+		if ctx.Err() != nil {
+			break
+		}
+		started++
+		go func(activity func(ctx context.Context) error) {
+			errChan <- activity(ctx)
+		}(activity)
+	}
+	var phaseErr error
+	for i := 0; i < started; i++ {
+		err := <-errChan
+		if err == nil || (e.failFast && phaseErr != nil) {
+			// With fail-fast, the errors after the first one are caused by its cancellation
+			continue
+		}
+		phaseErr = errors.Join(phaseErr, err)
+		cancel()
+	}
+	if started < len(phase) {
+		phaseErr = errors.Join(phaseErr, parent.Err())
+	}
+	return phaseErr
+}
+
+func (w *AsyncWorkflow) Execute(ctx context.Context) error {
+	validationPhase := []func(ctx context.Context) error{
 		w.s.ValidateCheckout,
 		w.s.ValidateAvailability,
 		w.s.VerifyCustomer,
 		w.s.ValidatePayment,
 	}
-
-	wg := &sync.WaitGroup{}
-	wg.Add(len(validationPhase))
-	for _, activity := range validationPhase {
-		go func(activity func()) {
-			defer wg.Done()
-			activity()
-		}(activity)
+	if err := w.exec.runPhase(ctx, validationPhase); err != nil {
+		return err
 	}
-	wg.Wait()
 
-	operationPhase := []func(){
+	operationPhase := []func(ctx context.Context) error{
 		w.s.RecordOffer,
 		w.s.CommitTax,
 		w.s.DecrementInventory,
 	}
-	wg.Add(len(operationPhase))
-	for _, activity := range operationPhase {
-		go func(activity func()) {
-			defer wg.Done()
-			activity()
-		}(activity)
+	if err := w.exec.runPhase(ctx, operationPhase); err != nil {
+		return err
 	}
-	wg.Wait()
-	w.s.CompleteOrder()
+	return w.s.CompleteOrder(ctx)
 }
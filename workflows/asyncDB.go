@@ -5,41 +5,243 @@ import (
 	"errors"
 	"fmt"
 	"github.com/Volume999/AsyncDB/asyncdb"
+	"github.com/Volume999/BroadleafSimulation/metrics"
 	"github.com/Volume999/BroadleafSimulation/simulator"
+	"github.com/Volume999/BroadleafSimulation/tracing"
+	"github.com/Volume999/BroadleafSimulation/workload"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"log"
+	"math/rand/v2"
 	"time"
 )
 
 const (
 	Concurrent = "concurrent"
 	Sequential = "sequential"
+	// Dependency executes the activities as soon as their dependencies finish (wfType only)
+	Dependency = "dag"
+	// Batched reads and writes all keys of a table in one batch of AsyncDB requests (simType only)
+	Batched = "batched"
+	// NonTransactionalBatched reads and writes all keys of a table in one batch of the store set with
+	// WithBatchStore (simType only). The batches bypass the transaction, see simulator.PgBatch, so the results
+	// are not comparable with the other simulators.
+	NonTransactionalBatched = "batched-nontxn"
 )
 
 type AsyncDBWorkflow struct {
-	db      *asyncdb.AsyncDB
-	l       *log.Logger
-	ctx     *asyncdb.ConnectionContext
-	s       *simulator.AsyncDBSimulator
-	simType string
-	keys    int
+	db       *asyncdb.AsyncDB
+	l        *log.Logger
+	ctx      *asyncdb.ConnectionContext
+	s        simulator.Simulator
+	wfType   string
+	simType  string
+	keys     *simulator.KeyAccess
+	bErrProb int
+	wrap     func(simulator.Simulator) simulator.Simulator
+	r        *rand.Rand
+	// base is the undecorated simulator of s, order and orderErrProb are the random stream
+	// and business error probability it was created with
+	base         *simulator.AsyncDBSimulator
+	order        string
+	orderErrProb int
+	saga         *SagaStats
+	sagaFailProb int
+	failFast     bool
+	rollbackHold *metrics.Histogram
+	retry        *RetryPolicy
+	outcomes     *Outcomes
+	fanOut       []func(*simulator.ConcTableReadWriteSimulator)
+	batch        simulator.BatchStore
+	providers    simulator.Providers
+	faults       *workload.FaultInjector
+	cache        *workload.Cache
 }
 
-func NewAsyncDBWorkflow(db *asyncdb.AsyncDB, l *log.Logger, simType string, keys int, bErrProb int) *AsyncDBWorkflow {
+type AsyncDBWorkflowOption func(*AsyncDBWorkflow)
+
+// WithSimulatorDecorator wraps every AsyncDB simulator the workflow creates, e.g. in simulator.WithContention.
+// Decorators are applied in the order of the options, the last one is the outermost.
+func WithSimulatorDecorator(wrap func(simulator.Simulator) simulator.Simulator) AsyncDBWorkflowOption {
+	return func(w *AsyncDBWorkflow) {
+		if inner := w.wrap; inner != nil {
+			w.wrap = func(s simulator.Simulator) simulator.Simulator {
+				return wrap(inner(s))
+			}
+			return
+		}
+		w.wrap = wrap
+	}
+}
+
+// WithRand sets the random stream the seeds of checkouts are drawn from.
+// Checkouts whose context already carries a seed (see simulator.WithSeed) use it instead.
+func WithRand(r *rand.Rand) AsyncDBWorkflowOption {
+	return func(w *AsyncDBWorkflow) {
+		w.r = r
+	}
+}
+
+// WithKeyAccess sets the distributions the keys of each table are drawn from, uniform by default
+func WithKeyAccess(keys *simulator.KeyAccess) AsyncDBWorkflowOption {
+	return func(w *AsyncDBWorkflow) {
+		w.keys = keys
+	}
+}
+
+// WithFailFast makes the first failed activity of a concurrent or dag workflow cancel its running siblings
+// and their pending DB calls, so a transaction that is going to be rolled back releases its locks sooner
+func WithFailFast() AsyncDBWorkflowOption {
+	return func(w *AsyncDBWorkflow) {
+		w.failFast = true
+	}
+}
+
+// WithRollbackHoldTimes records in h how long every transaction that is rolled back on a business error
+// held its locks: from the start of its last attempt until the rollback released them
+func WithRollbackHoldTimes(h *metrics.Histogram) AsyncDBWorkflowOption {
+	return func(w *AsyncDBWorkflow) {
+		w.rollbackHold = h
+	}
+}
+
+// WithRetryPolicy sets how aborted transactions are retried, by default immediately and without limit.
+// Share the policy between the workflows of a run to share its retry budget and statistics.
+func WithRetryPolicy(p *RetryPolicy) AsyncDBWorkflowOption {
+	return func(w *AsyncDBWorkflow) {
+		w.retry = p
+	}
+}
+
+// WithOutcomes records the outcome of every checkout in outcomes, share it between the workflows of a run
+func WithOutcomes(outcomes *Outcomes) AsyncDBWorkflowOption {
+	return func(w *AsyncDBWorkflow) {
+		w.outcomes = outcomes
+	}
+}
+
+// WithFanOut limits the DB requests a table read or write of the concurrent simulator has in flight to perCall
+// (0 means no limit), and the requests of all workflows sharing the global limiter (nil means no limit)
+func WithFanOut(perCall int, global *simulator.FanOutLimiter) AsyncDBWorkflowOption {
+	return func(w *AsyncDBWorkflow) {
+		w.fanOut = []func(*simulator.ConcTableReadWriteSimulator){simulator.WithFanOut(perCall)}
+		if global != nil {
+			w.fanOut = append(w.fanOut, simulator.WithGlobalFanOut(global))
+		}
+	}
+}
+
+// WithBatchStore sends the batches of the NonTransactionalBatched simulator to store, e.g. a simulator.PgBatch.
+// The batches of the Batched simulator are always issued on the workflow's AsyncDB connection, see simulator.AsyncDBBatch.
+func WithBatchStore(store simulator.BatchStore) AsyncDBWorkflowOption {
+	return func(w *AsyncDBWorkflow) {
+		w.batch = store
+	}
+}
+
+// WithProviders makes the checkouts call the remote services of providers, share them between the workflows
+// of a run to share their concurrency limits
+func WithProviders(providers simulator.Providers) AsyncDBWorkflowOption {
+	return func(w *AsyncDBWorkflow) {
+		w.providers = providers
+	}
+}
+
+// WithFaults injects the faults of the injector into the table reads and writes of the checkouts, share it
+// between the workflows of a run to share its statistics. Faults of an activity apply to the activity's reads
+// and writes; the compensations of sagas get the faults of their tables only.
+func WithFaults(faults *workload.FaultInjector) AsyncDBWorkflowOption {
+	return func(w *AsyncDBWorkflow) {
+		w.faults = faults
+	}
+}
+
+// WithCache serves the reads of simulator.CachedTables from the cache, see simulator.CachedTableReadWriteSimulator.
+// Share it between the workflows of a run, so they warm it up together.
+func WithCache(cache *workload.Cache) AsyncDBWorkflowOption {
+	return func(w *AsyncDBWorkflow) {
+		w.cache = cache
+	}
+}
+
+// NewAsyncDBWorkflow creates a workflow that executes checkouts in AsyncDB transactions.
+// wfType selects how the activities are executed (Sequential, Concurrent or Dependency workflow),
+// simType selects how the keys of one table are read and written (Sequential, Concurrent, Batched,
+// or NonTransactionalBatched with WithBatchStore).
+func NewAsyncDBWorkflow(db *asyncdb.AsyncDB, l *log.Logger, wfType string, simType string, keys int, bErrProb int, options ...AsyncDBWorkflowOption) *AsyncDBWorkflow {
 	// Connect
 	l.Println("Initializing Workflow")
 	ctx, _ := db.Connect()
+	w := &AsyncDBWorkflow{
+		db:       db,
+		l:        l,
+		ctx:      ctx,
+		wfType:   wfType,
+		simType:  simType,
+		keys:     simulator.UniformKeyAccess(keys),
+		bErrProb: bErrProb,
+		r:        rand.New(rand.NewPCG(rand.Uint64(), rand.Uint64())),
+		retry:    DefaultRetryPolicy(),
+	}
+	for _, option := range options {
+		option(w)
+	}
+	return w
+}
 
+// useOrder creates the simulator of the order drawn from the named random stream of the checkout in ctx.
+// The simulator works on the current connection.
+func (w *AsyncDBWorkflow) useOrder(ctx context.Context, order string, bErrProb int) {
 	var tableRWSimulator simulator.TableReadWriteSimulator
-	switch simType {
-	case "concurrent":
-		tableRWSimulator = simulator.NewConcTableReadWriteSimulator(db, ctx)
-	case "sequential":
-		tableRWSimulator = simulator.NewSyncTableReadWriteSimulator(db, ctx)
+	switch w.simType {
+	case Concurrent:
+		tableRWSimulator = simulator.NewConcTableReadWriteSimulator(w.db, w.ctx, w.fanOut...)
+	case Sequential:
+		tableRWSimulator = simulator.NewSyncTableReadWriteSimulator(w.db, w.ctx)
+	case Batched:
+		tableRWSimulator = simulator.NewBatchTableReadWriteSimulator(simulator.NewAsyncDBBatch(w.db, w.ctx))
+	case NonTransactionalBatched:
+		tableRWSimulator = simulator.NewBatchTableReadWriteSimulator(w.batch)
+	}
+	if w.faults != nil {
+		tableRWSimulator = simulator.NewFaultyTableReadWriteSimulator(tableRWSimulator, w.faults)
+	}
+	// Hits do not reach the tables, so they do not fail either
+	if w.cache != nil {
+		tableRWSimulator = simulator.NewCachedTableReadWriteSimulator(tableRWSimulator, w.cache)
 	}
+	w.base = simulator.NewAsyncDBSimulator(tableRWSimulator, w.l, simulator.ActivityRand(ctx, order), w.keys, bErrProb, w.providers)
+	w.order, w.orderErrProb = order, bErrProb
+	w.s = w.base
+	if w.faults != nil {
+		w.s = simulator.NewNamedActivities(w.s)
+	}
+	if w.wrap != nil {
+		w.s = w.wrap(w.s)
+	}
+}
+
+// reconnect moves the workflow and its current order to a new connection. Goroutines of an aborted
+// transaction may still issue requests on the old connection, so it is not reused.
+func (w *AsyncDBWorkflow) reconnect(ctx context.Context) {
+	//_ = w.db.Disconnect(w.ctx)
+	w.ctx, _ = w.db.Connect()
+	w.useOrder(ctx, w.order, w.orderErrProb)
+}
+
+func (w *AsyncDBWorkflow) executionOptions() []ExecutionOption {
+	if w.failFast {
+		return []ExecutionOption{FailFast()}
+	}
+	return nil
+}
 
-	sim := simulator.NewAsyncDBSimulator(tableRWSimulator, l, keys, bErrProb)
-	return &AsyncDBWorkflow{db, l, ctx, sim, simType, keys}
+// executor returns the workflow that executes the activities of the current simulator, see AsyncDBCheckoutSchedule
+func (w *AsyncDBWorkflow) executor() (Workflow, error) {
+	schedule, err := AsyncDBCheckoutSchedule(w.wfType)
+	if err != nil {
+		return nil, err
+	}
+	return NewDAGWorkflow(w.s, schedule, w.executionOptions()...), nil
 }
 
 func SetupAsyncDBSimulatedTablesWorkflow(db *asyncdb.AsyncDB, _ int) error {
@@ -58,6 +260,23 @@ func SetupAsyncDBSimulatedTablesWorkflow(db *asyncdb.AsyncDB, _ int) error {
 	return err
 }
 
+// SetupAsyncDBSimulatedTablesWithLatency creates simulated tables whose access times are drawn from latency.
+// Every table draws from its own stream, seeded from r.
+func SetupAsyncDBSimulatedTablesWithLatency(db *asyncdb.AsyncDB, latency workload.Latency, r *rand.Rand) error {
+	tables := []string{"Orders", "Items", "StockKeepingUnits", "Customers", "ItemOffers", "OrderPayments", "ItemOptions", "CustomerOffersUsage", "OrderTaxes"}
+	ctx, _ := db.Connect()
+
+	for _, table := range tables {
+		sampler := workload.NewLatencySampler(latency, rand.New(rand.NewPCG(r.Uint64(), r.Uint64())))
+		err := db.CreateTable(ctx, simulator.NewSimulatedTable(table, sampler))
+		if err != nil {
+			return err
+		}
+	}
+	err := db.Disconnect(ctx)
+	return err
+}
+
 func SetupAsyncDBInMemoryWorkflow(db *asyncdb.AsyncDB, keys int) error {
 	tables := []string{"Orders", "Items", "StockKeepingUnits", "Customers", "ItemOffers", "OrderPayments", "ItemOptions", "CustomerOffersUsage", "OrderTaxes"}
 	ctx, _ := db.Connect()
@@ -68,7 +287,7 @@ func SetupAsyncDBInMemoryWorkflow(db *asyncdb.AsyncDB, keys int) error {
 			return err
 		}
 		for i := 0; i <= keys; i++ {
-			err = tbl.Put(i, "value")
+			err = tbl.Put(i, simulator.InitialValue)
 			if err != nil {
 				return err
 			}
@@ -116,143 +335,154 @@ func SetupAsyncDBWorkflow(db *asyncdb.AsyncDB, pgFactory *asyncdb.PgTableFactory
 	return err
 }
 
-func (w *AsyncDBWorkflow) withTransaction(workflow func() error) {
+func isCancellation(err error) bool {
+	return errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+}
+
+// attemptContext returns the context of the n-th attempt of the checkout in ctx. Retries draw from
+// their own streams, so they do not repeat the random choices of the aborted attempt, e.g. of its locks.
+func attemptContext(ctx context.Context, n int) context.Context {
+	seed, ok := simulator.SeedFrom(ctx)
+	if !ok || n == 1 {
+		return ctx
+	}
+	return simulator.WithSeed(ctx, simulator.DeriveSeed(seed, uint64(n)))
+}
+
+// attempt runs one attempt of the transaction's workflow in a span
+func (w *AsyncDBWorkflow) attempt(ctx context.Context, n int, workflow func(ctx context.Context) error) error {
+	end := tracing.Start(ctx, tracing.CatAttempt, fmt.Sprintf("Attempt %d", n))
+	err := workflow(attemptContext(ctx, n))
+	end(err)
+	return err
+}
+
+// withTransaction runs workflow in a transaction, retrying it on aborts as the retry policy allows.
+// On a business error the transaction is rolled back. If ctx is done, the transaction is rolled back
+// and the outcome is Cancelled. If the policy gives up, the outcome is Aborted with ErrRetriesExhausted.
+// If the transaction cannot be begun, committed or rolled back, the outcome is Failed and the workflow
+// moves to a new connection.
+func (w *AsyncDBWorkflow) withTransaction(ctx context.Context, workflow func(ctx context.Context) error) Outcome {
 	w.l.Println("Starting Transaction")
-	err := w.db.BeginTransaction(w.ctx)
-	if err != nil {
-		panic("Failed to begin transaction: " + err.Error())
+	if err := w.db.BeginTransaction(w.ctx); err != nil {
+		return w.fail(ctx, 0, fmt.Errorf("failed to begin transaction: %w", err))
 	}
+	w.retry.begin()
 	ts := w.ctx.Txn.Timestamp()
-	err = workflow()
-	for err != nil {
+	attemptStart := time.Now()
+	var retryRand *rand.Rand
+	var delay time.Duration
+	err := w.attempt(ctx, 1, workflow)
+	attempt := 1
+	for ; err != nil; attempt++ {
 		w.l.Println("Workflow failed with error: ", err.Error())
-		if errors.Is(err, simulator.ErrBusinessLogic) {
-			err = w.db.RollbackTransaction(w.ctx)
+		if isCancellation(err) || ctx.Err() != nil {
+			if rollBackErr := w.db.RollbackTransaction(w.ctx); rollBackErr != nil {
+				return w.fail(ctx, attempt, fmt.Errorf("failed to rollback transaction: %w", rollBackErr))
+			}
+			w.l.Println("Transaction is rolled back: Cancelled")
+			// Cancelled reads and writes may still be in flight, they must not end up in the next transaction
+			w.ctx, _ = w.db.Connect()
+			return cancelledOutcome(ctx, attempt, err)
+		} else if errors.Is(err, simulator.ErrBusinessLogic) {
+			if rollBackErr := w.db.RollbackTransaction(w.ctx); rollBackErr != nil {
+				return w.fail(ctx, attempt, fmt.Errorf("failed to rollback transaction: %w", rollBackErr))
+			}
 			w.l.Println("Transaction is rolled back: Business error")
-			if err != nil {
-				panic("Failed to rollback transaction: " + err.Error())
+			if w.rollbackHold != nil {
+				w.rollbackHold.Record(time.Since(attemptStart))
 			}
-			return
+			if w.failFast {
+				// Cancelled activities may not have issued all their requests yet, they must not end up in the next transaction
+				w.ctx, _ = w.db.Connect()
+			}
+			return Outcome{Status: RolledBack, Attempts: attempt}
 		} else {
-			w.l.Println("Transaction is aborted: Retrying")
-			// You should just retry the workflow, but because concurrent executions can take over new transaction,
-			// I disconnect and connect again and start over
-			//err = workflow()
+			w.l.Println("Transaction is aborted")
+			// The locks are released before the backoff
 			if rollBackErr := w.db.RollbackTransaction(w.ctx); rollBackErr != nil {
-				panic("Failed to rollback transaction: " + rollBackErr.Error())
+				return w.fail(ctx, attempt, fmt.Errorf("failed to rollback transaction: %w", rollBackErr))
 			}
-			//_ = w.db.Disconnect(w.ctx)
+			// You should just retry the workflow, but because concurrent executions can take over new transaction,
+			// I disconnect and connect again and start over
 			w.ctx, _ = w.db.Connect()
+			if retryRand == nil {
+				retryRand = simulator.ActivityRand(ctx, "retry")
+			}
+			var retry bool
+			if delay, retry = w.retry.retry(retryRand, attempt, delay); !retry {
+				w.l.Printf("Transaction is not retried after %d attempts", attempt)
+				return Outcome{Status: Aborted, Attempts: attempt, Cause: errors.Join(ErrRetriesExhausted, err)}
+			}
+			if sleepErr := sleep(ctx, delay); sleepErr != nil {
+				w.l.Println("Transaction is rolled back: Cancelled")
+				return cancelledOutcome(ctx, attempt, sleepErr)
+			}
+			w.l.Println("Retrying transaction")
 			if err = w.db.BeginTransaction(w.ctx); err != nil {
-				panic("Failed to begin transaction: " + err.Error())
+				return w.fail(ctx, attempt, fmt.Errorf("failed to begin transaction: %w", err))
 			}
 			w.ctx.Txn.SetTimestamp(ts)
+			attemptStart = time.Now()
 			//w.s.SetConnCtx(w.ctx)
-			config := simulator.RandomConfig()
-			config.SetAccessKeys(w.keys)
-
-			var tableRWSimulator simulator.TableReadWriteSimulator
-			switch w.simType {
-			case "concurrent":
-				tableRWSimulator = simulator.NewConcTableReadWriteSimulator(w.db, w.ctx)
-			case "sequential":
-				tableRWSimulator = simulator.NewSyncTableReadWriteSimulator(w.db, w.ctx)
-			}
-
-			w.s = simulator.NewAsyncDBSimulator(tableRWSimulator, w.l, w.keys, 0)
-			err = workflow()
+			w.useOrder(ctx, fmt.Sprintf("Order/retry-%d", attempt), 0)
+			err = w.attempt(ctx, attempt+1, workflow)
 		}
 	}
-	err = w.db.CommitTransaction(w.ctx)
-	if err != nil {
-		panic("Failed to commit transaction: " + err.Error())
+	if err = w.db.CommitTransaction(w.ctx); err != nil {
+		return w.fail(ctx, attempt, fmt.Errorf("failed to commit transaction: %w", err))
 	}
+	w.retry.committed(attempt)
 	w.l.Println("Transaction is committed")
+	return Outcome{Status: Committed, Attempts: attempt}
 }
 
-func (w *AsyncDBWorkflow) ExecuteConcurrent() error {
-	validationPhase := []func() error{
-		w.s.ValidateCheckout,
-		w.s.ValidateAvailability,
-		w.s.VerifyCustomer,
-		w.s.ValidatePayment,
-	}
-
-	errChan := make(chan error, len(validationPhase))
-	for _, activity := range validationPhase {
-		go func(activity func() error) {
-			errChan <- activity()
-		}(activity)
-	}
-	var valErr error
-	for i := 0; i < len(validationPhase); i++ {
-		if err := <-errChan; err != nil {
-			valErr = errors.Join(valErr, err)
-		}
-	}
-	if valErr != nil {
-		return valErr
-	}
+// fail returns the Failed outcome of a transaction that could not be begun, committed or rolled back.
+// The state of the connection is unknown, so the workflow and its current order move to a new one.
+func (w *AsyncDBWorkflow) fail(ctx context.Context, attempts int, err error) Outcome {
+	w.l.Println("Transaction failed: ", err.Error())
+	w.reconnect(ctx)
+	return failedOutcome(attempts, err)
+}
 
-	operationPhase := []func() error{
-		w.s.RecordOffer,
-		w.s.CommitTax,
-		w.s.DecrementInventory,
+// Checkout runs one checkout of a new random order in a transaction, or as a saga (see WithSaga),
+// and returns its outcome
+func (w *AsyncDBWorkflow) Checkout(ctx context.Context) Outcome {
+	if _, ok := simulator.SeedFrom(ctx); !ok {
+		ctx = simulator.WithSeed(ctx, w.r.Uint64())
 	}
-	var opErr error
-	errChan = make(chan error, len(operationPhase))
-	for _, activity := range operationPhase {
-		go func(activity func() error) {
-			errChan <- activity()
-		}(activity)
+	if w.simType == NonTransactionalBatched && w.batch == nil {
+		return failedOutcome(0, errors.New("the non-transactional batched simulator needs a batch store"))
 	}
-	for i := 0; i < len(operationPhase); i++ {
-		if err := <-errChan; err != nil {
-			opErr = errors.Join(opErr, err)
-		}
+	if w.simType == NonTransactionalBatched && w.saga != nil {
+		return failedOutcome(0, simulator.ErrNonTransactionalSaga)
 	}
-	if opErr != nil {
-		return opErr
+	w.useOrder(ctx, "Order", w.bErrProb)
+	if _, err := w.executor(); err != nil {
+		return failedOutcome(0, err)
 	}
-	if err := w.s.CompleteOrder(); err != nil {
-		return err
+	if w.saga != nil {
+		return w.executeSaga(ctx)
 	}
-	return nil
+	return w.withTransaction(ctx, func(ctx context.Context) error {
+		// The simulator is replaced on retries, so the executor is created for every attempt
+		executor, _ := w.executor()
+		return executor.Execute(ctx)
+	})
 }
 
-func (w *AsyncDBWorkflow) ExecuteSequential() error {
-	if err := w.s.ValidateCheckout(); err != nil {
-		return err
-	}
-	if err := w.s.ValidateAvailability(); err != nil {
-		return err
+// Execute runs a checkout (see Checkout) and records its outcome (see WithOutcomes). It returns nil
+// if the checkout was committed or rolled back because of a business error, otherwise the cause
+// of the outcome: the cancellation error, ErrRetriesExhausted or the failure.
+func (w *AsyncDBWorkflow) Execute(ctx context.Context) error {
+	outcome := w.Checkout(ctx)
+	if w.outcomes != nil {
+		w.outcomes.Record(outcome)
 	}
-	if err := w.s.VerifyCustomer(); err != nil {
-		return err
-	}
-	if err := w.s.ValidatePayment(); err != nil {
-		return err
-	}
-	if err := w.s.RecordOffer(); err != nil {
-		return err
-	}
-	if err := w.s.CommitTax(); err != nil {
-		return err
-	}
-	if err := w.s.DecrementInventory(); err != nil {
-		return err
-	}
-	if err := w.s.CompleteOrder(); err != nil {
-		return err
-	}
-	return nil
+	return outcome.Err()
 }
 
-func (w *AsyncDBWorkflow) Execute(wfType string) {
-	switch wfType {
-	case Concurrent:
-		w.withTransaction(w.ExecuteConcurrent)
-	case Sequential:
-		w.withTransaction(w.ExecuteSequential)
-	}
+// Close disconnects the current connection of the workflow. The workflow must not be used afterwards.
+func (w *AsyncDBWorkflow) Close() error {
+	return w.db.Disconnect(w.ctx)
 }
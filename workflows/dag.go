@@ -0,0 +1,281 @@
+package workflows
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/Volume999/BroadleafSimulation/simulator"
+	"slices"
+)
+
+// Activity is a step of a DAG. Run is usually a method expression such as simulator.Simulator.RecordOffer,
+// so one DAG can be shared by the checkouts of all simulators.
+type Activity struct {
+	Name      string
+	Run       func(s simulator.Simulator, ctx context.Context) error
+	DependsOn []string
+}
+
+// DAG is a set of activities with explicit dependencies between them
+type DAG struct {
+	activities []Activity
+	// depCount is the number of dependencies of each activity
+	depCount []int
+	// dependents are the indices of the activities that depend on each activity
+	dependents [][]int
+}
+
+// NewDAG validates the activities: names must be unique, dependencies must exist and must not form a cycle
+func NewDAG(activities ...Activity) (*DAG, error) {
+	index := make(map[string]int, len(activities))
+	for i, activity := range activities {
+		if _, ok := index[activity.Name]; ok {
+			return nil, fmt.Errorf("duplicate activity %q", activity.Name)
+		}
+		index[activity.Name] = i
+	}
+	d := &DAG{
+		activities: activities,
+		depCount:   make([]int, len(activities)),
+		dependents: make([][]int, len(activities)),
+	}
+	for i, activity := range activities {
+		for _, dep := range activity.DependsOn {
+			j, ok := index[dep]
+			if !ok {
+				return nil, fmt.Errorf("activity %q depends on unknown activity %q", activity.Name, dep)
+			}
+			d.depCount[i]++
+			d.dependents[j] = append(d.dependents[j], i)
+		}
+	}
+	// Kahn's algorithm: all activities are reachable from the roots iff there is no cycle
+	pending := append([]int(nil), d.depCount...)
+	ready := d.roots()
+	visited := 0
+	for len(ready) > 0 {
+		i := ready[len(ready)-1]
+		ready = ready[:len(ready)-1]
+		visited++
+		for _, j := range d.dependents[i] {
+			if pending[j]--; pending[j] == 0 {
+				ready = append(ready, j)
+			}
+		}
+	}
+	if visited < len(activities) {
+		return nil, errors.New("activities have cyclic dependencies")
+	}
+	return d, nil
+}
+
+func mustDAG(activities ...Activity) *DAG {
+	d, err := NewDAG(activities...)
+	if err != nil {
+		panic(err)
+	}
+	return d
+}
+
+func (d *DAG) roots() []int {
+	var roots []int
+	for i, cnt := range d.depCount {
+		if cnt == 0 {
+			roots = append(roots, i)
+		}
+	}
+	return roots
+}
+
+// DependencyCheckout runs every activity as soon as the activities whose data it needs are finished.
+// Writes still wait for the validations they depend on, but not for unrelated ones,
+// e.g. RecordOffer does not wait for ValidateAvailability.
+var DependencyCheckout = mustDAG(
+	Activity{Name: "ValidateCheckout", Run: simulator.Simulator.ValidateCheckout},
+	Activity{Name: "ValidateAvailability", Run: simulator.Simulator.ValidateAvailability},
+	Activity{Name: "VerifyCustomer", Run: simulator.Simulator.VerifyCustomer},
+	Activity{Name: "ValidatePayment", Run: simulator.Simulator.ValidatePayment},
+	Activity{Name: "ValidateProductOption", Run: simulator.Simulator.ValidateProductOption},
+	Activity{Name: "RecordOffer", Run: simulator.Simulator.RecordOffer, DependsOn: []string{"ValidateCheckout", "VerifyCustomer"}},
+	Activity{Name: "CommitTax", Run: simulator.Simulator.CommitTax, DependsOn: []string{"ValidateCheckout", "ValidatePayment"}},
+	Activity{Name: "DecrementInventory", Run: simulator.Simulator.DecrementInventory, DependsOn: []string{"ValidateCheckout", "ValidateAvailability", "ValidateProductOption"}},
+	Activity{Name: "CompleteOrder", Run: simulator.Simulator.CompleteOrder, DependsOn: []string{"RecordOffer", "CommitTax", "DecrementInventory"}},
+)
+
+var validations = []string{"ValidateCheckout", "ValidateAvailability", "VerifyCustomer", "ValidatePayment"}
+
+// PhasedCheckout is the schedule of AsyncWorkflow: all validations, then RecordOffer, CommitTax and DecrementInventory,
+// then CompleteOrder. Like AsyncWorkflow, it does not validate the product options.
+var PhasedCheckout = mustDAG(
+	Activity{Name: "ValidateCheckout", Run: simulator.Simulator.ValidateCheckout},
+	Activity{Name: "ValidateAvailability", Run: simulator.Simulator.ValidateAvailability},
+	Activity{Name: "VerifyCustomer", Run: simulator.Simulator.VerifyCustomer},
+	Activity{Name: "ValidatePayment", Run: simulator.Simulator.ValidatePayment},
+	Activity{Name: "RecordOffer", Run: simulator.Simulator.RecordOffer, DependsOn: validations},
+	Activity{Name: "CommitTax", Run: simulator.Simulator.CommitTax, DependsOn: validations},
+	Activity{Name: "DecrementInventory", Run: simulator.Simulator.DecrementInventory, DependsOn: validations},
+	Activity{Name: "CompleteOrder", Run: simulator.Simulator.CompleteOrder, DependsOn: []string{"RecordOffer", "CommitTax", "DecrementInventory"}},
+)
+
+// SequentialCheckout is the schedule of SequentialWorkflow: every activity waits for the previous one
+var SequentialCheckout = mustDAG(
+	Activity{Name: "ValidateCheckout", Run: simulator.Simulator.ValidateCheckout},
+	Activity{Name: "ValidateAvailability", Run: simulator.Simulator.ValidateAvailability, DependsOn: []string{"ValidateCheckout"}},
+	Activity{Name: "VerifyCustomer", Run: simulator.Simulator.VerifyCustomer, DependsOn: []string{"ValidateAvailability"}},
+	Activity{Name: "ValidatePayment", Run: simulator.Simulator.ValidatePayment, DependsOn: []string{"VerifyCustomer"}},
+	Activity{Name: "ValidateProductOption", Run: simulator.Simulator.ValidateProductOption, DependsOn: []string{"ValidatePayment"}},
+	Activity{Name: "RecordOffer", Run: simulator.Simulator.RecordOffer, DependsOn: []string{"ValidateProductOption"}},
+	Activity{Name: "CommitTax", Run: simulator.Simulator.CommitTax, DependsOn: []string{"RecordOffer"}},
+	Activity{Name: "DecrementInventory", Run: simulator.Simulator.DecrementInventory, DependsOn: []string{"CommitTax"}},
+	Activity{Name: "CompleteOrder", Run: simulator.Simulator.CompleteOrder, DependsOn: []string{"DecrementInventory"}},
+)
+
+// AsyncDBPhasedCheckout, AsyncDBSequentialCheckout and AsyncDBDependencyCheckout are the schedules of concurrent,
+// sequential and dag AsyncDB checkouts: the schedules of the simulated workflows without ValidateProductOption
+var (
+	AsyncDBPhasedCheckout     = without(PhasedCheckout, "ValidateProductOption")
+	AsyncDBSequentialCheckout = without(SequentialCheckout, "ValidateProductOption")
+	AsyncDBDependencyCheckout = without(DependencyCheckout, "ValidateProductOption")
+)
+
+// without returns the DAG without the named activities. The activities that depended on a removed activity
+// depend on its dependencies instead, so the order of the remaining activities is kept.
+func without(d *DAG, names ...string) *DAG {
+	removed := make(map[string][]string, len(names))
+	for _, name := range names {
+		removed[name] = nil
+	}
+	for _, activity := range d.activities {
+		if _, ok := removed[activity.Name]; ok {
+			removed[activity.Name] = activity.DependsOn
+		}
+	}
+	// deps appends the dependencies that remain in place of dep
+	var deps func(res []string, dep string) []string
+	deps = func(res []string, dep string) []string {
+		if replaced, ok := removed[dep]; ok {
+			for _, r := range replaced {
+				res = deps(res, r)
+			}
+			return res
+		}
+		if slices.Contains(res, dep) {
+			return res
+		}
+		return append(res, dep)
+	}
+	var activities []Activity
+	for _, activity := range d.activities {
+		if _, ok := removed[activity.Name]; ok {
+			continue
+		}
+		var dependsOn []string
+		for _, dep := range activity.DependsOn {
+			dependsOn = deps(dependsOn, dep)
+		}
+		activity.DependsOn = dependsOn
+		activities = append(activities, activity)
+	}
+	return mustDAG(activities...)
+}
+
+// CheckoutSchedule returns the schedule of the activities in the workflow of type wfType
+// (Sequential, Concurrent or Dependency), e.g. for critical-path analysis
+func CheckoutSchedule(wfType string) (*DAG, error) {
+	switch wfType {
+	case Sequential:
+		return SequentialCheckout, nil
+	case Concurrent:
+		return PhasedCheckout, nil
+	case Dependency:
+		return DependencyCheckout, nil
+	}
+	return nil, fmt.Errorf("unknown workflow type %q", wfType)
+}
+
+// AsyncDBCheckoutSchedule returns the schedule of the activities of AsyncDBWorkflow in the workflow of type wfType
+// (Sequential, Concurrent or Dependency)
+func AsyncDBCheckoutSchedule(wfType string) (*DAG, error) {
+	switch wfType {
+	case Sequential:
+		return AsyncDBSequentialCheckout, nil
+	case Concurrent:
+		return AsyncDBPhasedCheckout, nil
+	case Dependency:
+		return AsyncDBDependencyCheckout, nil
+	}
+	return nil, fmt.Errorf("unknown workflow type %q", wfType)
+}
+
+// Dependencies maps every activity to the activities it depends on
+func (d *DAG) Dependencies() map[string][]string {
+	deps := make(map[string][]string, len(d.activities))
+	for _, activity := range d.activities {
+		deps[activity.Name] = activity.DependsOn
+	}
+	return deps
+}
+
+// DAGWorkflow runs the activities of a DAG, each as soon as all of its dependencies are finished
+type DAGWorkflow struct {
+	s    simulator.Simulator
+	dag  *DAG
+	exec execution
+}
+
+func NewDAGWorkflow(s simulator.Simulator, dag *DAG, options ...ExecutionOption) *DAGWorkflow {
+	return &DAGWorkflow{s: s, dag: dag, exec: newExecution(options)}
+}
+
+type activityResult struct {
+	i   int
+	err error
+}
+
+// Execute returns the joined errors of the failed activities. After the first failure, or once ctx
+// is done, no more activities are started, and the running ones are waited for (or cancelled, see FailFast).
+func (w *DAGWorkflow) Execute(ctx context.Context) error {
+	parent, cancel := ctx, context.CancelFunc(func() {})
+	if w.exec.failFast {
+		ctx, cancel = context.WithCancel(ctx)
+	}
+	defer cancel()
+	d := w.dag
+	pending := append([]int(nil), d.depCount...)
+	results := make(chan activityResult, len(d.activities))
+	started, running := 0, 0
+	start := func(i int) {
+		started++
+		running++
+		go func() {
+			results <- activityResult{i: i, err: d.activities[i].Run(w.s, ctx)}
+		}()
+	}
+	for _, i := range d.roots() {
+		if ctx.Err() != nil {
+			break
+		}
+		start(i)
+	}
+	var dagErr error
+	for running > 0 {
+		res := <-results
+		running--
+		if res.err != nil && !(w.exec.failFast && dagErr != nil) {
+			dagErr = errors.Join(dagErr, res.err)
+			cancel()
+		}
+		if dagErr != nil {
+			continue
+		}
+		for _, j := range d.dependents[res.i] {
+			if pending[j]--; pending[j] == 0 && ctx.Err() == nil {
+				start(j)
+			}
+		}
+	}
+	if started < len(d.activities) {
+		dagErr = errors.Join(dagErr, parent.Err())
+	}
+	return dagErr
+}
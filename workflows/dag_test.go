@@ -0,0 +1,212 @@
+package workflows
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingSimulator sleeps in every activity and records when it started and finished
+type recordingSimulator struct {
+	mu       sync.Mutex
+	start    map[string]time.Time
+	end      map[string]time.Time
+	duration map[string]time.Duration
+	fail     string
+}
+
+func newRecordingSimulator(duration map[string]time.Duration) *recordingSimulator {
+	return &recordingSimulator{start: map[string]time.Time{}, end: map[string]time.Time{}, duration: duration}
+}
+
+func (s *recordingSimulator) run(ctx context.Context, name string) error {
+	s.mu.Lock()
+	s.start[name] = time.Now()
+	s.mu.Unlock()
+	select {
+	case <-time.After(s.duration[name]):
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	s.mu.Lock()
+	s.end[name] = time.Now()
+	s.mu.Unlock()
+	if name == s.fail {
+		return errors.New(name + " failed")
+	}
+	return nil
+}
+
+func (s *recordingSimulator) ValidateCheckout(ctx context.Context) error {
+	return s.run(ctx, "ValidateCheckout")
+}
+
+func (s *recordingSimulator) ValidateAvailability(ctx context.Context) error {
+	return s.run(ctx, "ValidateAvailability")
+}
+
+func (s *recordingSimulator) VerifyCustomer(ctx context.Context) error {
+	return s.run(ctx, "VerifyCustomer")
+}
+
+func (s *recordingSimulator) ValidatePayment(ctx context.Context) error {
+	return s.run(ctx, "ValidatePayment")
+}
+
+func (s *recordingSimulator) ValidateProductOption(ctx context.Context) error {
+	return s.run(ctx, "ValidateProductOption")
+}
+
+func (s *recordingSimulator) RecordOffer(ctx context.Context) error {
+	return s.run(ctx, "RecordOffer")
+}
+
+func (s *recordingSimulator) CommitTax(ctx context.Context) error {
+	return s.run(ctx, "CommitTax")
+}
+
+func (s *recordingSimulator) CompleteOrder(ctx context.Context) error {
+	return s.run(ctx, "CompleteOrder")
+}
+
+func (s *recordingSimulator) DecrementInventory(ctx context.Context) error {
+	return s.run(ctx, "DecrementInventory")
+}
+
+func TestDependencyCheckoutRespectsDependencies(t *testing.T) {
+	s := newRecordingSimulator(map[string]time.Duration{"ValidateAvailability": 50 * time.Millisecond})
+	if err := NewDAGWorkflow(s, DependencyCheckout).Execute(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	for _, activity := range DependencyCheckout.activities {
+		if _, ok := s.end[activity.Name]; !ok {
+			t.Fatalf("%s did not run", activity.Name)
+		}
+		for _, dep := range activity.DependsOn {
+			if s.start[activity.Name].Before(s.end[dep]) {
+				t.Errorf("%s started before its dependency %s finished", activity.Name, dep)
+			}
+		}
+	}
+	// RecordOffer does not wait for the slow ValidateAvailability
+	if !s.end["RecordOffer"].Before(s.end["ValidateAvailability"]) {
+		t.Errorf("RecordOffer waited for ValidateAvailability")
+	}
+}
+
+func TestAsyncDBCheckoutsSkipProductOption(t *testing.T) {
+	for _, wfType := range []string{Sequential, Concurrent, Dependency} {
+		schedule, err := AsyncDBCheckoutSchedule(wfType)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s := newRecordingSimulator(nil)
+		if err = NewDAGWorkflow(s, schedule).Execute(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := s.start["ValidateProductOption"]; ok || len(s.end) != 8 {
+			t.Errorf("%s AsyncDB checkout ran %d activities, want the 8 without ValidateProductOption", wfType, len(s.end))
+		}
+	}
+}
+
+func TestWithoutKeepsTheOrder(t *testing.T) {
+	for _, tc := range []struct {
+		dag      *DAG
+		activity string
+		want     []string
+	}{
+		{AsyncDBSequentialCheckout, "RecordOffer", []string{"ValidatePayment"}},
+		{AsyncDBDependencyCheckout, "DecrementInventory", []string{"ValidateCheckout", "ValidateAvailability"}},
+		{sequentialValidations, "ValidatePayment", []string{"VerifyCustomer"}},
+	} {
+		if got := tc.dag.Dependencies()[tc.activity]; !slices.Equal(got, tc.want) {
+			t.Errorf("%s depends on %v, want %v", tc.activity, got, tc.want)
+		}
+	}
+	deps := parallelValidations.Dependencies()
+	if len(deps) != 4 {
+		t.Errorf("parallel saga validations %v, want the 4 validations", deps)
+	}
+	for activity, dependsOn := range deps {
+		if len(dependsOn) != 0 {
+			t.Errorf("parallel saga validation %s depends on %v", activity, dependsOn)
+		}
+	}
+}
+
+func TestAsyncWorkflowRunsPhasedCheckout(t *testing.T) {
+	s := newRecordingSimulator(nil)
+	if err := NewAsyncWorkflow(s).Execute(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.end) != len(PhasedCheckout.activities) {
+		t.Errorf("AsyncWorkflow ran %d activities, want the %d of PhasedCheckout", len(s.end), len(PhasedCheckout.activities))
+	}
+	for _, activity := range PhasedCheckout.activities {
+		if _, ok := s.end[activity.Name]; !ok {
+			t.Errorf("AsyncWorkflow did not run %s", activity.Name)
+		}
+	}
+}
+
+func TestDAGWorkflowStopsAfterFailure(t *testing.T) {
+	s := newRecordingSimulator(nil)
+	s.fail = "ValidatePayment"
+	err := NewDAGWorkflow(s, DependencyCheckout).Execute(context.Background())
+	if err == nil {
+		t.Fatal("expected the failure of ValidatePayment")
+	}
+	for _, name := range []string{"CommitTax", "CompleteOrder"} {
+		if _, ok := s.start[name]; ok {
+			t.Errorf("%s started after its dependency failed", name)
+		}
+	}
+}
+
+func TestFailFastCancelsSiblings(t *testing.T) {
+	for name, newWorkflow := range map[string]func(s *recordingSimulator) Workflow{
+		"async": func(s *recordingSimulator) Workflow { return NewAsyncWorkflow(s, FailFast()) },
+		"dag":   func(s *recordingSimulator) Workflow { return NewDAGWorkflow(s, DependencyCheckout, FailFast()) },
+	} {
+		t.Run(name, func(t *testing.T) {
+			s := newRecordingSimulator(map[string]time.Duration{"ValidateAvailability": time.Second, "ValidatePayment": 10 * time.Millisecond})
+			s.fail = "ValidatePayment"
+			start := time.Now()
+			err := newWorkflow(s).Execute(context.Background())
+			if err == nil || errors.Is(err, context.Canceled) {
+				t.Fatalf("expected only the failure of ValidatePayment, got %v", err)
+			}
+			if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+				t.Errorf("Execute took %v, ValidateAvailability was not cancelled", elapsed)
+			}
+			if _, ok := s.end["ValidateAvailability"]; ok {
+				t.Error("ValidateAvailability finished after its sibling failed")
+			}
+		})
+	}
+}
+
+func TestDAGWorkflowCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := NewDAGWorkflow(newRecordingSimulator(nil), DependencyCheckout).Execute(ctx)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+}
+
+func TestNewDAGRejectsInvalidDependencies(t *testing.T) {
+	if _, err := NewDAG(Activity{Name: "a", DependsOn: []string{"b"}}); err == nil {
+		t.Error("expected an error for an unknown dependency")
+	}
+	if _, err := NewDAG(Activity{Name: "a", DependsOn: []string{"b"}}, Activity{Name: "b", DependsOn: []string{"a"}}); err == nil {
+		t.Error("expected an error for a cycle")
+	}
+	if _, err := NewDAG(Activity{Name: "a"}, Activity{Name: "a"}); err == nil {
+		t.Error("expected an error for duplicate activities")
+	}
+}
@@ -1,5 +1,7 @@
 package workflows
 
+import "context"
+
 type LimitedConnectionsWorkflow struct {
 	w   Workflow
 	sem chan struct{}
@@ -12,8 +14,20 @@ func NewLimitedConnectionsWorkflow(workflow Workflow, limit int) *LimitedConnect
 	}
 }
 
-func (w *LimitedConnectionsWorkflow) Execute() {
-	w.sem <- struct{}{}
+func (w *LimitedConnectionsWorkflow) Execute(ctx context.Context) error {
+	select {
+	case w.sem <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 	defer func() { <-w.sem }()
-	w.w.Execute()
+	return w.w.Execute(ctx)
+}
+
+// Wrap returns a decorator of another workflow that shares the same connection limit
+func (w *LimitedConnectionsWorkflow) Wrap(workflow Workflow) *LimitedConnectionsWorkflow {
+	return &LimitedConnectionsWorkflow{
+		w:   workflow,
+		sem: w.sem,
+	}
 }
@@ -0,0 +1,148 @@
+package workflows
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// OutcomeStatus is how the transaction of a checkout ended
+type OutcomeStatus string
+
+const (
+	// Committed checkouts committed their transaction, or completed all saga steps
+	Committed OutcomeStatus = "committed"
+	// RolledBack checkouts were rolled back, or compensated, because of a business error
+	RolledBack OutcomeStatus = "rolled-back"
+	// Aborted checkouts were aborted by lock conflicts until the retry policy gave up
+	Aborted OutcomeStatus = "aborted"
+	// Cancelled checkouts were cancelled or exceeded their deadline
+	Cancelled OutcomeStatus = "cancelled"
+	// Failed checkouts could not begin, commit or roll back a transaction, or compensate a saga step
+	Failed OutcomeStatus = "failed"
+)
+
+var outcomeStatuses = []OutcomeStatus{Committed, RolledBack, Aborted, Cancelled, Failed}
+
+// Outcome is the result of one checkout
+type Outcome struct {
+	Status OutcomeStatus
+	// Attempts is the number of attempts of the checkout's transaction
+	Attempts int
+	// Cause is the error that ended a checkout that was not committed or rolled back
+	Cause error
+}
+
+// Err returns nil for committed and rolled back checkouts, and the cause of the other outcomes
+func (o Outcome) Err() error {
+	switch o.Status {
+	case Committed, RolledBack:
+		return nil
+	}
+	return o.Cause
+}
+
+func (o Outcome) String() string {
+	switch o.Status {
+	case Committed, RolledBack:
+		return fmt.Sprintf("%s after %d attempt(s)", o.Status, o.Attempts)
+	}
+	return fmt.Sprintf("%s after %d attempt(s): %v", o.Status, o.Attempts, o.Cause)
+}
+
+func cancelledOutcome(ctx context.Context, attempts int, err error) Outcome {
+	if !isCancellation(err) {
+		err = errors.Join(ctx.Err(), err)
+	}
+	return Outcome{Status: Cancelled, Attempts: attempts, Cause: err}
+}
+
+func failedOutcome(attempts int, err error) Outcome {
+	return Outcome{Status: Failed, Attempts: attempts, Cause: err}
+}
+
+// maxFailureCauses bounds the number of distinct failure causes Outcomes keeps
+const maxFailureCauses = 20
+
+// Outcomes counts the outcomes of the checkouts of a run. It is safe for concurrent use.
+type Outcomes struct {
+	counts   sync.Map // OutcomeStatus -> *atomic.Int64
+	attempts atomic.Int64
+	mu       sync.Mutex
+	causes   map[string]int
+}
+
+func NewOutcomes() *Outcomes {
+	return &Outcomes{causes: make(map[string]int)}
+}
+
+func (o *Outcomes) Record(outcome Outcome) {
+	cnt, _ := o.counts.LoadOrStore(outcome.Status, &atomic.Int64{})
+	cnt.(*atomic.Int64).Add(1)
+	o.attempts.Add(int64(outcome.Attempts))
+	if outcome.Status != Failed {
+		return
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	cause := outcome.Cause.Error()
+	if _, ok := o.causes[cause]; ok || len(o.causes) < maxFailureCauses {
+		o.causes[cause]++
+	}
+}
+
+// Count returns the number of checkouts with the status
+func (o *Outcomes) Count(status OutcomeStatus) int {
+	if cnt, ok := o.counts.Load(status); ok {
+		return int(cnt.(*atomic.Int64).Load())
+	}
+	return 0
+}
+
+// Total returns the number of recorded checkouts
+func (o *Outcomes) Total() int {
+	total := 0
+	for _, status := range outcomeStatuses {
+		total += o.Count(status)
+	}
+	return total
+}
+
+// Attempts returns the number of transaction attempts of all recorded checkouts
+func (o *Outcomes) Attempts() int {
+	return int(o.attempts.Load())
+}
+
+// FailureCauses returns the number of failed checkouts by cause, for at most the first 20 distinct causes
+func (o *Outcomes) FailureCauses() map[string]int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	res := make(map[string]int, len(o.causes))
+	for cause, cnt := range o.causes {
+		res[cause] = cnt
+	}
+	return res
+}
+
+func (o *Outcomes) String() string {
+	parts := make([]string, 0, len(outcomeStatuses)+1)
+	for _, status := range outcomeStatuses {
+		parts = append(parts, fmt.Sprintf("%s=%d", status, o.Count(status)))
+	}
+	parts = append(parts, fmt.Sprintf("attempts=%d", o.Attempts()))
+	res := strings.Join(parts, " ")
+	causes := o.FailureCauses()
+	keys := make([]string, 0, len(causes))
+	for cause := range causes {
+		keys = append(keys, cause)
+	}
+	sort.Slice(keys, func(i, j int) bool { return causes[keys[i]] > causes[keys[j]] })
+	for _, cause := range keys {
+		res += fmt.Sprintf("\n  %d failed: %s", causes[cause], cause)
+	}
+	return res
+}
@@ -0,0 +1,74 @@
+package workflows
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"testing"
+)
+
+func TestCheckoutOutcomes(t *testing.T) {
+	db := newInMemoryDB(t, 100)
+	l := log.New(io.Discard, "", 0)
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	for _, tc := range []struct {
+		name     string
+		bErrProb int
+		ctx      context.Context
+		want     OutcomeStatus
+	}{
+		{"committed", 0, context.Background(), Committed},
+		{"business error", 100, context.Background(), RolledBack},
+		{"cancelled", 0, cancelled, Cancelled},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			outcomes := NewOutcomes()
+			w := NewAsyncDBWorkflow(db, l, Concurrent, Concurrent, 100, tc.bErrProb, WithOutcomes(outcomes))
+			err := w.Execute(tc.ctx)
+			if outcomes.Count(tc.want) != 1 || outcomes.Total() != 1 {
+				t.Errorf("outcomes %v, want one %s checkout", outcomes, tc.want)
+			}
+			if (tc.want == Cancelled) != (err != nil) {
+				t.Errorf("Execute() = %v", err)
+			}
+		})
+	}
+}
+
+func TestCheckoutFailsOnBrokenConnection(t *testing.T) {
+	db := newInMemoryDB(t, 100)
+	w := NewAsyncDBWorkflow(db, log.New(io.Discard, "", 0), Sequential, Sequential, 100, 0)
+	// A transaction left open on the connection makes beginning the next one fail
+	if err := db.BeginTransaction(w.ctx); err != nil {
+		t.Fatal(err)
+	}
+	outcome := w.Checkout(context.Background())
+	if outcome.Status != Failed || outcome.Cause == nil {
+		t.Fatalf("outcome %v, want a failure", outcome)
+	}
+	// The workflow moved to a new connection
+	if outcome = w.Checkout(context.Background()); outcome.Status != Committed {
+		t.Errorf("outcome after the failure %v, want committed", outcome)
+	}
+}
+
+func TestOutcomesKeepFailureCauses(t *testing.T) {
+	outcomes := NewOutcomes()
+	outcomes.Record(Outcome{Status: Committed, Attempts: 2})
+	for range 3 {
+		outcomes.Record(failedOutcome(1, errors.New("commit failed")))
+	}
+	if outcomes.Total() != 4 || outcomes.Attempts() != 5 || outcomes.FailureCauses()["commit failed"] != 3 {
+		t.Errorf("unexpected outcomes %v", outcomes)
+	}
+}
+
+func TestNonTransactionalBatchedNeedsBatchStore(t *testing.T) {
+	db := newInMemoryDB(t, 100)
+	w := NewAsyncDBWorkflow(db, log.New(io.Discard, "", 0), Concurrent, NonTransactionalBatched, 100, 0)
+	if outcome := w.Checkout(context.Background()); outcome.Status != Failed {
+		t.Errorf("outcome %v without a batch store, want a failure", outcome)
+	}
+}
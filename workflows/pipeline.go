@@ -0,0 +1,160 @@
+package workflows
+
+import (
+	"context"
+	"fmt"
+	"github.com/Volume999/BroadleafSimulation/simulator"
+	"sync"
+)
+
+// Pipeline executes checkouts in three stages: validation, operations and completion. Every stage has
+// its own workers, and the stages are connected by bounded channels, so the validation workers take
+// the next order while the later stages finish the previous ones. A full channel blocks the stage
+// before it, and a full validation channel blocks Execute.
+//
+// The activities of a stage run one after another (Sequential) or concurrently like the phases
+// of AsyncWorkflow (Concurrent). An order that fails or is cancelled in a stage skips the later ones.
+type Pipeline struct {
+	s      simulator.Simulator
+	stages []pipelineStage
+	in     chan *pipelineOrder
+	exec   execution
+	wg     sync.WaitGroup
+}
+
+type pipelineStage struct {
+	name       string
+	activities []func(s simulator.Simulator, ctx context.Context) error
+}
+
+var checkoutStages = []pipelineStage{
+	{"validation", []func(s simulator.Simulator, ctx context.Context) error{
+		simulator.Simulator.ValidateCheckout,
+		simulator.Simulator.ValidateAvailability,
+		simulator.Simulator.VerifyCustomer,
+		simulator.Simulator.ValidatePayment,
+		simulator.Simulator.ValidateProductOption,
+	}},
+	{"operations", []func(s simulator.Simulator, ctx context.Context) error{
+		simulator.Simulator.RecordOffer,
+		simulator.Simulator.CommitTax,
+		simulator.Simulator.DecrementInventory,
+	}},
+	{"completion", []func(s simulator.Simulator, ctx context.Context) error{
+		simulator.Simulator.CompleteOrder,
+	}},
+}
+
+type pipelineOrder struct {
+	ctx context.Context
+	// done receives the result of the order, it is buffered so the stages never wait for Execute
+	done chan error
+}
+
+// NewPipeline starts workers goroutines for every stage, connected by channels of queue orders each.
+// wfType selects how the activities of a stage run, Sequential or Concurrent. Close stops the workers.
+func NewPipeline(s simulator.Simulator, wfType string, workers int, queue int, options ...ExecutionOption) (*Pipeline, error) {
+	if wfType != Sequential && wfType != Concurrent {
+		return nil, fmt.Errorf("unknown pipeline stage execution %q", wfType)
+	}
+	if workers < 1 || queue < 0 {
+		return nil, fmt.Errorf("invalid pipeline of %d workers and %d queued orders per stage", workers, queue)
+	}
+	p := &Pipeline{s: s, stages: checkoutStages, exec: newExecution(options)}
+	run := p.runSequential
+	if wfType == Concurrent {
+		run = p.runConcurrent
+	}
+	p.in = make(chan *pipelineOrder, queue)
+	in := p.in
+	for i, stage := range p.stages {
+		var out chan *pipelineOrder
+		if i < len(p.stages)-1 {
+			out = make(chan *pipelineOrder, queue)
+		}
+		stageWg := &sync.WaitGroup{}
+		for range workers {
+			stageWg.Add(1)
+			go func(in <-chan *pipelineOrder) {
+				defer stageWg.Done()
+				p.work(stage, run, in, out)
+			}(in)
+		}
+		// The next stage stops once all workers of this one are done
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			stageWg.Wait()
+			if out != nil {
+				close(out)
+			}
+		}()
+		in = out
+	}
+	return p, nil
+}
+
+func (p *Pipeline) work(stage pipelineStage, run func(stage pipelineStage, ctx context.Context) error,
+	in <-chan *pipelineOrder, out chan<- *pipelineOrder) {
+	for order := range in {
+		if err := order.ctx.Err(); err != nil {
+			order.done <- err
+			continue
+		}
+		if err := run(stage, order.ctx); err != nil {
+			order.done <- err
+			continue
+		}
+		if out == nil {
+			order.done <- nil
+			continue
+		}
+		out <- order
+	}
+}
+
+func (p *Pipeline) runSequential(stage pipelineStage, ctx context.Context) error {
+	for _, activity := range stage.activities {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := activity(p.s, ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *Pipeline) runConcurrent(stage pipelineStage, ctx context.Context) error {
+	phase := make([]func(ctx context.Context) error, len(stage.activities))
+	for i, activity := range stage.activities {
+		phase[i] = func(ctx context.Context) error {
+			return activity(p.s, ctx)
+		}
+	}
+	return p.exec.runPhase(ctx, phase)
+}
+
+// Execute puts the checkout into the pipeline and waits until it leaves it. If ctx is done first,
+// Execute returns ctx.Err() at once and the stages drop the order when they get to it.
+// Execute must not be called after Close.
+func (p *Pipeline) Execute(ctx context.Context) error {
+	order := &pipelineOrder{ctx: ctx, done: make(chan error, 1)}
+	select {
+	case p.in <- order:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case err := <-order.done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Close stops the workers after they finished the orders in the pipeline
+func (p *Pipeline) Close() {
+	close(p.in)
+	p.wg.Wait()
+}
@@ -0,0 +1,63 @@
+package workflows
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPipelineOverlapsStages(t *testing.T) {
+	duration := map[string]time.Duration{}
+	for _, name := range []string{"ValidateCheckout", "ValidateAvailability", "VerifyCustomer", "ValidatePayment",
+		"ValidateProductOption", "RecordOffer", "CommitTax", "DecrementInventory", "CompleteOrder"} {
+		duration[name] = 20 * time.Millisecond
+	}
+	p, err := NewPipeline(newRecordingSimulator(duration), Sequential, 1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	const orders = 4
+	start := time.Now()
+	wg := sync.WaitGroup{}
+	for range orders {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := p.Execute(context.Background()); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	// One worker per stage: the orders queue for the 100ms validation stage, while the 60ms operations
+	// and 20ms completion of the previous orders overlap with it. Without pipelining it takes 4*180ms.
+	if elapsed := time.Since(start); elapsed > 650*time.Millisecond {
+		t.Errorf("%d pipelined orders took %v, want about %v", orders, elapsed, 480*time.Millisecond)
+	}
+}
+
+func TestPipelineSkipsStagesAfterFailure(t *testing.T) {
+	s := newRecordingSimulator(map[string]time.Duration{})
+	s.fail = "ValidatePayment"
+	p, err := NewPipeline(s, Concurrent, 2, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = p.Execute(context.Background()); err == nil {
+		t.Fatal("order with a failed validation succeeded")
+	}
+	p.Close()
+	if _, ok := s.start["RecordOffer"]; ok {
+		t.Error("operations stage ran after a failed validation")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	p, _ = NewPipeline(s, Concurrent, 1, 0)
+	defer p.Close()
+	if err = p.Execute(ctx); err != context.Canceled {
+		t.Errorf("cancelled order returned %v", err)
+	}
+}
@@ -0,0 +1,306 @@
+package workflows
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrRetriesExhausted is returned by a checkout whose aborted transaction is not retried any more,
+// because it reached the maximum number of attempts or the retry budget is used up
+var ErrRetriesExhausted = errors.New("transaction retries exhausted")
+
+// Backoff kinds
+const (
+	NoBackoff          = "none"
+	FixedBackoff       = "fixed"
+	ExponentialBackoff = "exponential"
+)
+
+// Jitter kinds
+const (
+	NoJitter           = "none"
+	FullJitter         = "full"
+	DecorrelatedJitter = "decorrelated"
+)
+
+// RetryPolicy decides whether and when an aborted transaction is retried. It is shared by the workflows
+// of a run, so the retry budget and the statistics cover all of them.
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of attempts of a transaction, 0 means no limit
+	MaxAttempts int
+	// Backoff is the kind of delay before a retry: NoBackoff, FixedBackoff (BaseDelay)
+	// or ExponentialBackoff (BaseDelay doubled with every retry, at most MaxDelay)
+	Backoff   string
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// Jitter randomizes the delay: NoJitter, FullJitter (uniform between 0 and the delay)
+	// or DecorrelatedJitter (uniform between BaseDelay and 3 times the previous delay, at most MaxDelay)
+	Jitter string
+	// Budget limits the retries to this fraction of the transactions, 0 means no budget
+	Budget float64
+
+	budgetInit sync.Once
+	budget     *retryBudget
+	stats      retryCounters
+}
+
+// retryBudget is a token bucket: every transaction deposits ratio tokens and every retry withdraws one.
+// The bucket starts with minRetryTokens, so a run can retry before it has many transactions.
+type retryBudget struct {
+	mu     sync.Mutex
+	ratio  float64
+	tokens float64
+}
+
+const (
+	minRetryTokens = 10
+	maxRetryTokens = 1000
+)
+
+func (b *retryBudget) deposit() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.tokens = min(b.tokens+b.ratio, maxRetryTokens)
+}
+
+func (b *retryBudget) withdraw() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+type retryCounters struct {
+	transactions      atomic.Int64
+	commits           atomic.Int64
+	committedAttempts atomic.Int64
+	retries           atomic.Int64
+	maxAttemptsGiveUp atomic.Int64
+	budgetGiveUp      atomic.Int64
+	backoff           atomic.Int64
+}
+
+// RetryStats are the statistics of a retry policy
+type RetryStats struct {
+	Transactions int
+	Commits      int
+	// AttemptsPerCommit is the mean number of attempts of the committed transactions
+	AttemptsPerCommit float64
+	Retries           int
+	// GiveUps is the number of transactions that were not retried any more, BudgetGiveUps of them
+	// because the retry budget was used up
+	GiveUps       int
+	BudgetGiveUps int
+	// Backoff is the total time spent waiting before retries
+	Backoff time.Duration
+}
+
+func (s RetryStats) String() string {
+	return fmt.Sprintf("transactions=%d commits=%d attempts/commit=%.2f retries=%d giveUps=%d (budget %d) backoff=%v",
+		s.Transactions, s.Commits, s.AttemptsPerCommit, s.Retries, s.GiveUps, s.BudgetGiveUps, s.Backoff.Round(time.Millisecond))
+}
+
+// DefaultRetryPolicy retries aborted transactions immediately and without limit
+func DefaultRetryPolicy() *RetryPolicy {
+	return &RetryPolicy{Backoff: NoBackoff, Jitter: NoJitter}
+}
+
+// ContentionRetrySpec is the default retry policy of runs with contention locks (see simulator.WithContention).
+// Transactions that time out waiting for a lock back off before they retry, so they do not keep timing each
+// other out, and eventually give up.
+const ContentionRetrySpec = "attempts=20,backoff=exponential:5ms:500ms,jitter=full"
+
+// ParseRetryPolicy parses a comma-separated list of settings, e.g. "attempts=10,backoff=exponential:1ms:100ms,jitter=full,budget=0.2":
+//   - attempts=<n> - maximum number of attempts, 0 for no limit
+//   - backoff=none, fixed:<delay> or exponential:<base>:<max>
+//   - jitter=none, full or decorrelated
+//   - budget=<ratio> - retries allowed per transaction on average, 0 for no budget
+//
+// Settings that are not given keep their defaults, see DefaultRetryPolicy.
+func ParseRetryPolicy(spec string) (*RetryPolicy, error) {
+	p := DefaultRetryPolicy()
+	if spec == "" {
+		return p, p.validate()
+	}
+	for _, entry := range strings.Split(spec, ",") {
+		name, value, found := strings.Cut(strings.TrimSpace(entry), "=")
+		if !found {
+			return nil, fmt.Errorf("retry policy setting %q is not <name>=<value>", entry)
+		}
+		var err error
+		switch name {
+		case "attempts":
+			p.MaxAttempts, err = strconv.Atoi(value)
+		case "backoff":
+			err = p.parseBackoff(value)
+		case "jitter":
+			p.Jitter = value
+		case "budget":
+			p.Budget, err = strconv.ParseFloat(value, 64)
+		default:
+			return nil, fmt.Errorf("unknown retry policy setting %q", name)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid retry policy setting %q: %w", entry, err)
+		}
+	}
+	return p, p.validate()
+}
+
+func (p *RetryPolicy) parseBackoff(spec string) error {
+	kind, args, _ := strings.Cut(spec, ":")
+	var params []string
+	if args != "" {
+		params = strings.Split(args, ":")
+	}
+	delays := make([]time.Duration, len(params))
+	for i, param := range params {
+		d, err := time.ParseDuration(param)
+		if err != nil {
+			return err
+		}
+		delays[i] = d
+	}
+	p.Backoff = kind
+	switch {
+	case kind == NoBackoff && len(delays) == 0:
+		p.BaseDelay, p.MaxDelay = 0, 0
+	case kind == FixedBackoff && len(delays) == 1:
+		p.BaseDelay, p.MaxDelay = delays[0], delays[0]
+	case kind == ExponentialBackoff && len(delays) == 2:
+		p.BaseDelay, p.MaxDelay = delays[0], delays[1]
+	default:
+		return fmt.Errorf("backoff is none, fixed:<delay> or exponential:<base>:<max>")
+	}
+	return nil
+}
+
+func (p *RetryPolicy) validate() error {
+	switch p.Backoff {
+	case NoBackoff, FixedBackoff, ExponentialBackoff:
+	default:
+		return fmt.Errorf("unknown backoff %q", p.Backoff)
+	}
+	switch p.Jitter {
+	case NoJitter, FullJitter, DecorrelatedJitter:
+	default:
+		return fmt.Errorf("unknown jitter %q", p.Jitter)
+	}
+	if p.MaxAttempts < 0 || p.Budget < 0 || p.BaseDelay < 0 || p.MaxDelay < p.BaseDelay {
+		return fmt.Errorf("invalid retry policy %v", p)
+	}
+	return nil
+}
+
+func (p *RetryPolicy) String() string {
+	backoff := p.Backoff
+	switch p.Backoff {
+	case FixedBackoff:
+		backoff += ":" + p.BaseDelay.String()
+	case ExponentialBackoff:
+		backoff += ":" + p.BaseDelay.String() + ":" + p.MaxDelay.String()
+	}
+	return fmt.Sprintf("attempts=%d,backoff=%s,jitter=%s,budget=%g", p.MaxAttempts, backoff, p.Jitter, p.Budget)
+}
+
+// Stats returns the statistics of the transactions executed with the policy so far
+func (p *RetryPolicy) Stats() RetryStats {
+	c := &p.stats
+	s := RetryStats{
+		Transactions:  int(c.transactions.Load()),
+		Commits:       int(c.commits.Load()),
+		Retries:       int(c.retries.Load()),
+		GiveUps:       int(c.maxAttemptsGiveUp.Load() + c.budgetGiveUp.Load()),
+		BudgetGiveUps: int(c.budgetGiveUp.Load()),
+		Backoff:       time.Duration(c.backoff.Load()),
+	}
+	if s.Commits > 0 {
+		s.AttemptsPerCommit = float64(c.committedAttempts.Load()) / float64(s.Commits)
+	}
+	return s
+}
+
+// begin counts a new transaction
+func (p *RetryPolicy) begin() {
+	p.stats.transactions.Add(1)
+	if p.Budget > 0 {
+		p.budgetOnce().deposit()
+	}
+}
+
+func (p *RetryPolicy) budgetOnce() *retryBudget {
+	p.budgetInit.Do(func() {
+		p.budget = &retryBudget{ratio: p.Budget, tokens: minRetryTokens}
+	})
+	return p.budget
+}
+
+// committed counts a transaction committed in the given number of attempts
+func (p *RetryPolicy) committed(attempts int) {
+	p.stats.commits.Add(1)
+	p.stats.committedAttempts.Add(int64(attempts))
+}
+
+// retry decides whether a transaction aborted in its attempt-th attempt is retried, and how long to wait before.
+// prev is the previous delay of the transaction.
+func (p *RetryPolicy) retry(r *rand.Rand, attempt int, prev time.Duration) (time.Duration, bool) {
+	if p.MaxAttempts > 0 && attempt >= p.MaxAttempts {
+		p.stats.maxAttemptsGiveUp.Add(1)
+		return 0, false
+	}
+	if p.Budget > 0 && !p.budgetOnce().withdraw() {
+		p.stats.budgetGiveUp.Add(1)
+		return 0, false
+	}
+	p.stats.retries.Add(1)
+	delay := p.delay(r, attempt, prev)
+	p.stats.backoff.Add(int64(delay))
+	return delay, true
+}
+
+func (p *RetryPolicy) delay(r *rand.Rand, attempt int, prev time.Duration) time.Duration {
+	if p.Backoff == NoBackoff {
+		return 0
+	}
+	delay := p.BaseDelay
+	if p.Backoff == ExponentialBackoff {
+		// Doubled with every retry, attempt is at least 1
+		delay = p.MaxDelay
+		if attempt-1 < 62 && p.BaseDelay<<(attempt-1) < p.MaxDelay && p.BaseDelay<<(attempt-1) > 0 {
+			delay = p.BaseDelay << (attempt - 1)
+		}
+	}
+	switch p.Jitter {
+	case FullJitter:
+		return time.Duration(r.Int64N(int64(delay) + 1))
+	case DecorrelatedJitter:
+		upper := max(3*prev, p.BaseDelay)
+		return min(p.BaseDelay+time.Duration(r.Int64N(int64(upper-p.BaseDelay)+1)), p.MaxDelay)
+	}
+	return delay
+}
+
+// sleep waits for the delay, or until ctx is done
+func sleep(ctx context.Context, delay time.Duration) error {
+	if delay <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,171 @@
+package workflows
+
+import (
+	"context"
+	"errors"
+	"github.com/Volume999/AsyncDB/asyncdb"
+	"github.com/Volume999/BroadleafSimulation/simulator"
+	"github.com/Volume999/BroadleafSimulation/workload"
+	"io"
+	"log"
+	"math/rand/v2"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParseRetryPolicy(t *testing.T) {
+	p, err := ParseRetryPolicy("attempts=5,backoff=exponential:1ms:50ms,jitter=full,budget=0.5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.MaxAttempts != 5 || p.Backoff != ExponentialBackoff || p.BaseDelay != time.Millisecond ||
+		p.MaxDelay != 50*time.Millisecond || p.Jitter != FullJitter || p.Budget != 0.5 {
+		t.Errorf("unexpected policy %v", p)
+	}
+	for _, spec := range []string{"attempts", "backoff=fixed", "backoff=exponential:10ms:1ms", "jitter=some", "retries=3"} {
+		if _, err := ParseRetryPolicy(spec); err == nil {
+			t.Errorf("ParseRetryPolicy(%q) did not fail", spec)
+		}
+	}
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	r := rand.New(rand.NewPCG(1, 2))
+	p, _ := ParseRetryPolicy("backoff=exponential:1ms:10ms")
+	var delays []time.Duration
+	for attempt := 1; attempt <= 6; attempt++ {
+		d, ok := p.retry(r, attempt, 0)
+		if !ok {
+			t.Fatalf("attempt %d was not retried", attempt)
+		}
+		delays = append(delays, d)
+	}
+	want := []time.Duration{1, 2, 4, 8, 10, 10}
+	for i := range want {
+		if delays[i] != want[i]*time.Millisecond {
+			t.Errorf("delays = %v, want %v ms", delays, want)
+			break
+		}
+	}
+
+	for _, jitter := range []string{FullJitter, DecorrelatedJitter} {
+		p, _ := ParseRetryPolicy("backoff=exponential:1ms:10ms,jitter=" + jitter)
+		prev := time.Duration(0)
+		for attempt := 1; attempt <= 100; attempt++ {
+			d, _ := p.retry(r, attempt, prev)
+			if d < 0 || d > 10*time.Millisecond || (jitter == DecorrelatedJitter && d < time.Millisecond) {
+				t.Fatalf("%s jitter delay %v is out of bounds", jitter, d)
+			}
+			prev = d
+		}
+	}
+}
+
+func TestRetryPolicyGivesUp(t *testing.T) {
+	r := rand.New(rand.NewPCG(1, 2))
+	p, _ := ParseRetryPolicy("attempts=3")
+	p.begin()
+	for attempt := 1; attempt <= 2; attempt++ {
+		if _, ok := p.retry(r, attempt, 0); !ok {
+			t.Fatalf("attempt %d was not retried", attempt)
+		}
+	}
+	if _, ok := p.retry(r, 3, 0); ok {
+		t.Error("the third attempt was retried")
+	}
+
+	budget, _ := ParseRetryPolicy("budget=0.5")
+	for range 10 {
+		budget.begin()
+	}
+	retries := 0
+	for range 100 {
+		if _, ok := budget.retry(r, 1, 0); ok {
+			retries++
+		}
+	}
+	// The initial tokens and half a token per transaction
+	if retries != minRetryTokens+5 {
+		t.Errorf("%d retries allowed, want %d", retries, minRetryTokens+5)
+	}
+	if s := budget.Stats(); s.Retries != retries || s.BudgetGiveUps != 100-retries || s.Transactions != 10 {
+		t.Errorf("unexpected stats %v", s)
+	}
+}
+
+// seedRecorder records the seeds the checkouts validated by the simulator were run with
+type seedRecorder struct {
+	simulator.Simulator
+	mu    *sync.Mutex
+	seeds *[]uint64
+}
+
+func (s seedRecorder) ValidateCheckout(ctx context.Context) error {
+	seed, _ := simulator.SeedFrom(ctx)
+	s.mu.Lock()
+	*s.seeds = append(*s.seeds, seed)
+	s.mu.Unlock()
+	return s.Simulator.ValidateCheckout(ctx)
+}
+
+func TestRetriesDrawNewSeeds(t *testing.T) {
+	db := newInMemoryDB(t, 100)
+	plan, err := simulator.ParseFaultPlan("CompleteOrder=error:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy, _ := ParseRetryPolicy("attempts=3")
+	var seeds []uint64
+	w := NewAsyncDBWorkflow(db, log.New(io.Discard, "", 0), Sequential, Sequential, 100, 0,
+		WithFaults(workload.NewFaultInjector(plan, simulator.ActivityRand)),
+		WithRetryPolicy(policy),
+		WithSimulatorDecorator(func(s simulator.Simulator) simulator.Simulator {
+			return seedRecorder{Simulator: s, mu: &sync.Mutex{}, seeds: &seeds}
+		}))
+	outcome := w.Checkout(simulator.WithSeed(context.Background(), 7))
+	if outcome.Status != Aborted || !errors.Is(outcome.Err(), ErrRetriesExhausted) || outcome.Attempts != 3 {
+		t.Fatalf("outcome %v, want aborted after 3 attempts", outcome)
+	}
+	if len(seeds) != 3 || seeds[0] != 7 || seeds[1] == seeds[0] || seeds[2] == seeds[0] || seeds[2] == seeds[1] {
+		t.Errorf("attempts ran with seeds %v, want the checkout's seed 7 and then a new one per retry", seeds)
+	}
+}
+
+func TestContendedCheckoutsComplete(t *testing.T) {
+	// Checkouts on simulated tables hold their contention locks longer than a fixed 100ms lock timeout
+	// would allow 20 of them to queue up for 5 locks
+	db := asyncdb.NewAsyncDB(asyncdb.NewTransactionManager(), asyncdb.NewLockManager(), asyncdb.NewStringHasher(), asyncdb.WithExplicitTxn())
+	if err := SetupAsyncDBSimulatedTablesWorkflow(db, 0); err != nil {
+		t.Fatal(err)
+	}
+	policy, err := ParseRetryPolicy(ContentionRetrySpec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	contention := simulator.NewWithContention(nil, 5, simulator.WithScaledLockTimeout())
+	outcomes := NewOutcomes()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	const workers, checkouts = 20, 2
+	wg := sync.WaitGroup{}
+	for i := range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w := NewAsyncDBWorkflow(db, log.New(io.Discard, "", 0), Concurrent, Concurrent, 100000, 0,
+				WithRand(simulator.NewWorkerRand(1, uint64(i))), WithRetryPolicy(policy), WithOutcomes(outcomes),
+				WithSimulatorDecorator(func(s simulator.Simulator) simulator.Simulator {
+					return contention.Wrap(s)
+				}))
+			defer w.Close()
+			for range checkouts {
+				_ = w.Execute(ctx)
+			}
+		}()
+	}
+	wg.Wait()
+	if outcomes.Count(Committed) != workers*checkouts {
+		t.Errorf("outcomes %v, want %d committed checkouts", outcomes, workers*checkouts)
+	}
+}
@@ -0,0 +1,238 @@
+package workflows
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/Volume999/BroadleafSimulation/simulator"
+	"github.com/Volume999/BroadleafSimulation/tracing"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	// sagaStepAttempts bounds the attempts of a step transaction aborted by lock conflicts
+	sagaStepAttempts = 5
+	// compensationAttempts bounds the attempts of a compensating transaction. A compensation keeps the timestamp
+	// of its first attempt, so with wait-die locking it eventually wins over younger transactions.
+	compensationAttempts = 50
+)
+
+// SagaStats counts the outcomes of saga checkouts. It is shared by the workflows of a run.
+type SagaStats struct {
+	// Completed sagas committed all steps
+	Completed atomic.Int64
+	// Rejected sagas failed validation, or failed or were cancelled before committing a step
+	Rejected atomic.Int64
+	// Compensated sagas failed after committing some steps and compensated all of them
+	Compensated atomic.Int64
+	// Inconsistent sagas failed and left committed steps whose compensation failed
+	Inconsistent atomic.Int64
+	// Compensations is the number of committed compensating transactions
+	Compensations atomic.Int64
+
+	mu sync.Mutex
+	// uncompensated counts the committed steps of failed sagas that were not compensated, by step
+	uncompensated map[string]int
+}
+
+func NewSagaStats() *SagaStats {
+	return &SagaStats{uncompensated: make(map[string]int)}
+}
+
+func (s *SagaStats) addUncompensated(step string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.uncompensated[step]++
+}
+
+// Uncompensated returns the number of committed steps of failed sagas that were not compensated, by step.
+// These are the writes that make the end state of the tables inconsistent.
+func (s *SagaStats) Uncompensated() map[string]int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	res := make(map[string]int, len(s.uncompensated))
+	for step, cnt := range s.uncompensated {
+		res[step] = cnt
+	}
+	return res
+}
+
+func (s *SagaStats) String() string {
+	uncompensated := s.Uncompensated()
+	steps := make([]string, 0, len(uncompensated))
+	for step, cnt := range uncompensated {
+		steps = append(steps, fmt.Sprintf("%s=%d", step, cnt))
+	}
+	sort.Strings(steps)
+	consistency := "consistent"
+	if len(steps) > 0 {
+		consistency = "inconsistent, uncompensated steps: " + strings.Join(steps, " ")
+	}
+	return fmt.Sprintf("completed=%d rejected=%d compensated=%d inconsistent=%d compensations=%d, end state %s",
+		s.Completed.Load(), s.Rejected.Load(), s.Compensated.Load(), s.Inconsistent.Load(), s.Compensations.Load(), consistency)
+}
+
+// WithSaga executes checkouts as sagas: the validations run in one transaction, then every operation
+// activity commits in its own transaction. If a step fails, the committed steps are compensated in reverse
+// order. failProb is the probability (0-100) that a step fails after doing its work, e.g. because the
+// payment is declined. The outcomes are counted in stats.
+func WithSaga(stats *SagaStats, failProb int) AsyncDBWorkflowOption {
+	return func(w *AsyncDBWorkflow) {
+		w.saga = stats
+		w.sagaFailProb = failProb
+	}
+}
+
+type sagaStep struct {
+	name       string
+	run        func(s simulator.Simulator, ctx context.Context) error
+	compensate func(s *simulator.AsyncDBSimulator, ctx context.Context) error
+}
+
+var checkoutSaga = []sagaStep{
+	{"RecordOffer", simulator.Simulator.RecordOffer, (*simulator.AsyncDBSimulator).CompensateRecordOffer},
+	{"CommitTax", simulator.Simulator.CommitTax, (*simulator.AsyncDBSimulator).CompensateCommitTax},
+	{"DecrementInventory", simulator.Simulator.DecrementInventory, (*simulator.AsyncDBSimulator).CompensateDecrementInventory},
+	{"CompleteOrder", simulator.Simulator.CompleteOrder, (*simulator.AsyncDBSimulator).CompensateCompleteOrder},
+}
+
+// parallelValidations and sequentialValidations are the validations of the AsyncDB schedules, the saga steps
+// run in transactions of their own
+var (
+	parallelValidations   = without(AsyncDBPhasedCheckout, sagaStepNames()...)
+	sequentialValidations = without(AsyncDBSequentialCheckout, sagaStepNames()...)
+)
+
+func sagaStepNames() []string {
+	names := make([]string, len(checkoutSaga))
+	for i, step := range checkoutSaga {
+		names[i] = step.name
+	}
+	return names
+}
+
+// errUncompensated is the cause of the Failed outcome of sagas that left committed steps that could not be compensated
+var errUncompensated = errors.New("saga steps could not be compensated")
+
+// sagaTransaction runs f in its own transaction, retrying it on aborts at most maxAttempts times
+// with the timestamp of the first attempt. Business errors and cancellation are not retried.
+func (w *AsyncDBWorkflow) sagaTransaction(ctx context.Context, name string, maxAttempts int, f func(ctx context.Context) error) Outcome {
+	var ts int64
+	for attempt := 1; ; attempt++ {
+		if err := w.db.BeginTransaction(w.ctx); err != nil {
+			return w.fail(ctx, attempt-1, fmt.Errorf("failed to begin transaction: %w", err))
+		}
+		if attempt == 1 {
+			ts = w.ctx.Txn.Timestamp()
+		} else {
+			w.ctx.Txn.SetTimestamp(ts)
+		}
+		end := tracing.Start(ctx, tracing.CatSaga, fmt.Sprintf("%s %d", name, attempt))
+		err := f(attemptContext(ctx, attempt))
+		end(err)
+		if err == nil {
+			if err = w.db.CommitTransaction(w.ctx); err != nil {
+				return w.fail(ctx, attempt, fmt.Errorf("failed to commit transaction: %w", err))
+			}
+			return Outcome{Status: Committed, Attempts: attempt}
+		}
+		w.l.Printf("%s failed with error: %v", name, err)
+		if rollBackErr := w.db.RollbackTransaction(w.ctx); rollBackErr != nil {
+			return w.fail(ctx, attempt, errors.Join(err, fmt.Errorf("failed to rollback transaction: %w", rollBackErr)))
+		}
+		// Reads and writes of the failed attempt may still be in flight, they must not end up in the next transaction
+		w.reconnect(ctx)
+		switch {
+		case isCancellation(err) || ctx.Err() != nil:
+			return cancelledOutcome(ctx, attempt, err)
+		case errors.Is(err, simulator.ErrBusinessLogic):
+			return Outcome{Status: RolledBack, Attempts: attempt}
+		case attempt == maxAttempts:
+			return Outcome{Status: Aborted, Attempts: attempt, Cause: errors.Join(ErrRetriesExhausted, err)}
+		}
+	}
+}
+
+// executeSaga runs the checkout of the current order as a saga, see WithSaga. The outcome of a saga whose
+// step failed is the outcome of the step, or Failed if not all committed steps could be compensated.
+// Attempts counts the attempts of all transactions of the saga.
+func (w *AsyncDBWorkflow) executeSaga(ctx context.Context) Outcome {
+	validations := parallelValidations
+	if w.wfType == Sequential {
+		validations = sequentialValidations
+	}
+	outcome := w.withTransaction(ctx, func(ctx context.Context) error {
+		return NewDAGWorkflow(w.s, validations, w.executionOptions()...).Execute(ctx)
+	})
+	if outcome.Status != Committed {
+		w.saga.Rejected.Add(1)
+		return outcome
+	}
+	attempts := outcome.Attempts
+	// The steps write a value of their own, which the compensations take out again
+	seed, _ := simulator.SeedFrom(ctx)
+	value := sagaValue(seed)
+	for i, step := range checkoutSaga {
+		outcome = w.sagaTransaction(ctx, step.name, sagaStepAttempts, func(ctx context.Context) error {
+			if err := step.run(w.s, simulator.WithSagaWrite(ctx, value)); err != nil {
+				return err
+			}
+			if simulator.RandomChance(simulator.ActivityRand(ctx, "saga/"+step.name), w.sagaFailProb) {
+				return simulator.ErrBusinessLogic
+			}
+			return nil
+		})
+		attempts += outcome.Attempts
+		if outcome.Status != Committed {
+			w.l.Printf("Saga failed at %s, compensating %d steps", step.name, i)
+			compensations, consistent := w.compensate(simulator.WithCompensation(ctx, value), checkoutSaga[:i])
+			if !consistent {
+				outcome = failedOutcome(0, errors.Join(errUncompensated, outcome.Cause))
+			}
+			outcome.Attempts = attempts + compensations
+			return outcome
+		}
+	}
+	w.saga.Completed.Add(1)
+	return Outcome{Status: Committed, Attempts: attempts}
+}
+
+// sagaValue is the value the steps of the saga of the checkout with the given seed write
+func sagaValue(seed uint64) string {
+	return fmt.Sprintf("order-%d", seed)
+}
+
+// compensate undoes the committed steps in reverse order, taking out the value of the saga in ctx
+// (see simulator.WithCompensation), and reports the attempts of the compensating
+// transactions and whether all steps were compensated. Compensations run even if the checkout was cancelled.
+func (w *AsyncDBWorkflow) compensate(ctx context.Context, committed []sagaStep) (int, bool) {
+	if len(committed) == 0 {
+		w.saga.Rejected.Add(1)
+		return 0, true
+	}
+	ctx = context.WithoutCancel(ctx)
+	attempts, consistent := 0, true
+	for i := len(committed) - 1; i >= 0; i-- {
+		step := committed[i]
+		outcome := w.sagaTransaction(ctx, "Compensate"+step.name, compensationAttempts, func(ctx context.Context) error {
+			return step.compensate(w.base, ctx)
+		})
+		attempts += outcome.Attempts
+		if outcome.Status != Committed {
+			w.l.Printf("Compensation of %s failed: %v", step.name, outcome.Cause)
+			consistent = false
+			w.saga.addUncompensated(step.name)
+			continue
+		}
+		w.saga.Compensations.Add(1)
+	}
+	if consistent {
+		w.saga.Compensated.Add(1)
+	} else {
+		w.saga.Inconsistent.Add(1)
+	}
+	return attempts, consistent
+}
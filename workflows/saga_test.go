@@ -0,0 +1,273 @@
+package workflows
+
+import (
+	"context"
+	"errors"
+	"github.com/Volume999/AsyncDB/asyncdb"
+	"github.com/Volume999/BroadleafSimulation/simulator"
+	"github.com/Volume999/BroadleafSimulation/workload"
+	"io"
+	"log"
+	"sync"
+	"testing"
+)
+
+func newInMemoryDB(t *testing.T, keys int) *asyncdb.AsyncDB {
+	db := asyncdb.NewAsyncDB(asyncdb.NewTransactionManager(), asyncdb.NewLockManager(), asyncdb.NewStringHasher(), asyncdb.WithExplicitTxn())
+	if err := SetupAsyncDBInMemoryWorkflow(db, keys); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestSagaCompensatesCommittedSteps(t *testing.T) {
+	db := newInMemoryDB(t, 1000)
+	stats := NewSagaStats()
+	l := log.New(io.Discard, "", 0)
+	const workers, checkouts = 4, 25
+	wg := sync.WaitGroup{}
+	for i := range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w := NewAsyncDBWorkflow(db, l, Concurrent, Concurrent, 1000, 0,
+				WithRand(simulator.NewWorkerRand(1, uint64(i))), WithSaga(stats, 30))
+			for range checkouts {
+				if err := w.Execute(context.Background()); err != nil {
+					t.Errorf("Execute() = %v", err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	total := stats.Completed.Load() + stats.Rejected.Load() + stats.Compensated.Load() + stats.Inconsistent.Load()
+	if total != workers*checkouts {
+		t.Errorf("%d saga outcomes, want %d", total, workers*checkouts)
+	}
+	if stats.Compensated.Load() == 0 || stats.Compensations.Load() < stats.Compensated.Load() {
+		t.Errorf("compensated=%d compensations=%d, want failed sagas to be compensated", stats.Compensated.Load(), stats.Compensations.Load())
+	}
+	if stats.Inconsistent.Load() != 0 || len(stats.Uncompensated()) != 0 {
+		t.Errorf("inconsistent=%d uncompensated=%v, want a consistent end state", stats.Inconsistent.Load(), stats.Uncompensated())
+	}
+}
+
+func TestConcurrentSagasKeepCompletedValues(t *testing.T) {
+	// Few keys, so the sagas write the same keys and compensate them in any order
+	const keys, workers, checkouts = 50, 4, 25
+	db := newInMemoryDB(t, keys)
+	stats := NewSagaStats()
+	mu := sync.Mutex{}
+	completed := map[string]bool{simulator.InitialValue: true}
+	wg := sync.WaitGroup{}
+	for i := range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w := NewAsyncDBWorkflow(db, log.New(io.Discard, "", 0), Concurrent, Concurrent, keys, 0, WithSaga(stats, 30))
+			for n := range checkouts {
+				seed := simulator.DeriveSeed(uint64(i), uint64(n))
+				if outcome := w.Checkout(simulator.WithSeed(context.Background(), seed)); outcome.Status == Committed {
+					mu.Lock()
+					completed[sagaValue(seed)] = true
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if stats.Compensated.Load() == 0 || stats.Completed.Load() == 0 {
+		t.Fatalf("saga outcomes %v, want completed and compensated sagas", stats)
+	}
+
+	written := 0
+	for table, values := range tableValues(t, db, keys, "CustomerOffersUsage", "OrderTaxes", "StockKeepingUnits", "Orders") {
+		for key, value := range values {
+			if top := simulator.TopValue(value); !completed[top] {
+				t.Errorf("%s key %d holds %q, want %q or the value of a completed saga", table, key, top, simulator.InitialValue)
+			} else if top != simulator.InitialValue {
+				written++
+			}
+		}
+	}
+	if written == 0 {
+		t.Error("no key holds the value of a completed saga")
+	}
+}
+
+// interleavedSimulator runs another checkout before it fails CompleteOrder
+type interleavedSimulator struct {
+	simulator.Simulator
+	interleave func()
+}
+
+func (s interleavedSimulator) CompleteOrder(context.Context) error {
+	s.interleave()
+	return simulator.ErrBusinessLogic
+}
+
+func TestSagaCompensationKeepsLaterWrites(t *testing.T) {
+	// Saga b writes the keys of saga a after a committed its steps and before a compensates them
+	const keys = 2
+	tables := []string{"CustomerOffersUsage", "OrderTaxes", "StockKeepingUnits"}
+	db := newInMemoryDB(t, keys)
+	stats := NewSagaStats()
+	l := log.New(io.Discard, "", 0)
+	b := NewAsyncDBWorkflow(db, l, Sequential, Sequential, keys, 0, WithSaga(stats, 0))
+	var outcomeB Outcome
+	a := NewAsyncDBWorkflow(db, l, Sequential, Sequential, keys, 0, WithSaga(stats, 0),
+		WithSimulatorDecorator(func(s simulator.Simulator) simulator.Simulator {
+			return interleavedSimulator{Simulator: s, interleave: func() {
+				outcomeB = b.Checkout(simulator.WithSeed(context.Background(), 2))
+			}}
+		}))
+	if outcome := a.Checkout(simulator.WithSeed(context.Background(), 1)); outcome.Status != RolledBack || stats.Compensated.Load() != 1 {
+		t.Fatalf("outcome of a %v with sagas %v, want a compensated saga", outcome, stats)
+	}
+	if outcomeB.Status != Committed {
+		t.Fatalf("outcome of b %v, want committed", outcomeB)
+	}
+	for table, values := range tableValues(t, db, keys, tables...) {
+		written := 0
+		for key, value := range values {
+			switch simulator.TopValue(value) {
+			case sagaValue(2):
+				written++
+			case simulator.InitialValue:
+			default:
+				t.Errorf("%s key %d holds %q, want the value of b or %q", table, key, value, simulator.InitialValue)
+			}
+		}
+		if written == 0 {
+			t.Errorf("the compensation of a erased the writes of b to %s", table)
+		}
+	}
+}
+
+func TestSagaRejectsFailedValidation(t *testing.T) {
+	db := newInMemoryDB(t, 100)
+	stats := NewSagaStats()
+	w := NewAsyncDBWorkflow(db, log.New(io.Discard, "", 0), Sequential, Sequential, 100, 100,
+		WithRand(simulator.NewWorkerRand(1, 0)), WithSaga(stats, 0))
+	if err := w.Execute(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if stats.Rejected.Load() != 1 || stats.Compensations.Load() != 0 {
+		t.Errorf("rejected=%d compensations=%d, want a rejected saga without compensations", stats.Rejected.Load(), stats.Compensations.Load())
+	}
+}
+
+// tableValues returns the values of the keys of the tables, by table
+func tableValues(t *testing.T, db *asyncdb.AsyncDB, keys int, tables ...string) map[string][]string {
+	ctx, _ := db.Connect()
+	if err := db.BeginTransaction(ctx); err != nil {
+		t.Fatal(err)
+	}
+	values := make(map[string][]string)
+	for _, table := range tables {
+		for key := 0; key <= keys; key++ {
+			res := <-db.Get(ctx, table, key)
+			if res.Err != nil {
+				t.Fatal(res.Err)
+			}
+			value, _ := res.Data.(string)
+			values[table] = append(values[table], value)
+		}
+	}
+	if err := db.CommitTransaction(ctx); err != nil {
+		t.Fatal(err)
+	}
+	return values
+}
+
+// changedKeys returns the keys of the tables whose value is not simulator.InitialValue, by table
+func changedKeys(t *testing.T, db *asyncdb.AsyncDB, keys int, tables ...string) map[string][]int {
+	changed := make(map[string][]int)
+	for table, values := range tableValues(t, db, keys, tables...) {
+		for key, value := range values {
+			if value != simulator.InitialValue {
+				changed[table] = append(changed[table], key)
+			}
+		}
+	}
+	return changed
+}
+
+func TestSagaCompensationRestoresTables(t *testing.T) {
+	// The tables written by the saga steps, CompleteOrder fails after RecordOffer, CommitTax and DecrementInventory committed
+	tables := []string{"CustomerOffersUsage", "OrderTaxes", "StockKeepingUnits", "Orders"}
+	const keys = 100
+	checkout := func(faults string) (*asyncdb.AsyncDB, Outcome, *SagaStats) {
+		db := newInMemoryDB(t, keys)
+		stats := NewSagaStats()
+		options := []AsyncDBWorkflowOption{WithRand(simulator.NewWorkerRand(1, 0)), WithSaga(stats, 0)}
+		if faults != "" {
+			plan, err := simulator.ParseFaultPlan(faults)
+			if err != nil {
+				t.Fatal(err)
+			}
+			options = append(options, WithFaults(workload.NewFaultInjector(plan, simulator.ActivityRand)))
+		}
+		w := NewAsyncDBWorkflow(db, log.New(io.Discard, "", 0), Sequential, Sequential, keys, 0, options...)
+		return db, w.Checkout(context.Background()), stats
+	}
+
+	// The same order without the failure leaves its writes behind, so the check below can see them
+	db, outcome, _ := checkout("")
+	if outcome.Status != Committed {
+		t.Fatalf("outcome %v, want committed", outcome)
+	}
+	if changed := changedKeys(t, db, keys, tables...); len(changed) == 0 {
+		t.Fatal("a completed saga changed no keys")
+	}
+
+	db, outcome, stats := checkout("CompleteOrder=error:1")
+	if outcome.Status != Aborted || !errors.Is(outcome.Err(), workload.ErrInjected) {
+		t.Fatalf("outcome %v, want aborted by the failure of CompleteOrder", outcome)
+	}
+	if stats.Compensated.Load() != 1 || stats.Compensations.Load() != 3 {
+		t.Errorf("compensated=%d compensations=%d, want the 3 committed steps compensated", stats.Compensated.Load(), stats.Compensations.Load())
+	}
+	if changed := changedKeys(t, db, keys, tables...); len(changed) != 0 {
+		t.Errorf("keys %v are not back to their value before the saga", changed)
+	}
+}
+
+// brokenCommitSimulator ends the transaction of the workflow after CompleteOrder, so committing it fails
+type brokenCommitSimulator struct {
+	simulator.Simulator
+	end func()
+}
+
+func (s brokenCommitSimulator) CompleteOrder(ctx context.Context) error {
+	err := s.Simulator.CompleteOrder(ctx)
+	s.end()
+	return err
+}
+
+func TestSagaCompensatesOnNewConnectionAfterFailure(t *testing.T) {
+	tables := []string{"CustomerOffersUsage", "OrderTaxes", "StockKeepingUnits", "Orders"}
+	const keys = 100
+	db := newInMemoryDB(t, keys)
+	stats := NewSagaStats()
+	var w *AsyncDBWorkflow
+	w = NewAsyncDBWorkflow(db, log.New(io.Discard, "", 0), Sequential, Sequential, keys, 0, WithSaga(stats, 0),
+		WithSimulatorDecorator(func(s simulator.Simulator) simulator.Simulator {
+			return brokenCommitSimulator{Simulator: s, end: func() { _ = db.RollbackTransaction(w.ctx) }}
+		}))
+	outcome := w.Checkout(simulator.WithSeed(context.Background(), 1))
+	if outcome.Status != Failed || errors.Is(outcome.Err(), errUncompensated) {
+		t.Fatalf("outcome %v, want the failed commit of CompleteOrder", outcome)
+	}
+	// The validations, the 4 steps and the 3 compensations, none of them retried on the broken connection
+	if outcome.Attempts != 8 {
+		t.Errorf("%d attempts, want 8", outcome.Attempts)
+	}
+	if stats.Compensated.Load() != 1 || stats.Compensations.Load() != 3 {
+		t.Errorf("compensated=%d compensations=%d, want the 3 committed steps compensated", stats.Compensated.Load(), stats.Compensations.Load())
+	}
+	if changed := changedKeys(t, db, keys, tables...); len(changed) != 0 {
+		t.Errorf("keys %v are not back to their value before the saga", changed)
+	}
+}
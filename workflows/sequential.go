@@ -1,6 +1,7 @@
 package workflows
 
 import (
+	"context"
 	"github.com/Volume999/BroadleafSimulation/simulator"
 )
 
@@ -12,16 +13,18 @@ func NewSequentialWorkflow(simulator simulator.Simulator) *SequentialWorkflow {
 	return &SequentialWorkflow{s: simulator}
 }
 
-func execFnAsync(f func()) {
-	c := make(chan struct{})
+func execFnAsync(ctx context.Context, f func(ctx context.Context) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	c := make(chan error)
 	go func() {
-		f()
-		close(c)
+		c <- f(ctx)
 	}()
-	<-c
+	return <-c
 }
 
-func (w *SequentialWorkflow) Execute() {
+func (w *SequentialWorkflow) Execute(ctx context.Context) error {
 	//w.s.ValidateCheckout()
 	//w.s.ValidateAvailability()
 	//w.s.VerifyCustomer()
@@ -30,13 +33,21 @@ func (w *SequentialWorkflow) Execute() {
 	//w.s.CommitTax()
 	//w.s.DecrementInventory()
 	//w.s.CompleteOrder()
-	execFnAsync(w.s.ValidateCheckout)
-	execFnAsync(w.s.ValidateAvailability)
-	execFnAsync(w.s.VerifyCustomer)
-	execFnAsync(w.s.ValidatePayment)
-	execFnAsync(w.s.ValidateProductOption)
-	execFnAsync(w.s.RecordOffer)
-	execFnAsync(w.s.CommitTax)
-	execFnAsync(w.s.DecrementInventory)
-	execFnAsync(w.s.CompleteOrder)
+	activities := []func(ctx context.Context) error{
+		w.s.ValidateCheckout,
+		w.s.ValidateAvailability,
+		w.s.VerifyCustomer,
+		w.s.ValidatePayment,
+		w.s.ValidateProductOption,
+		w.s.RecordOffer,
+		w.s.CommitTax,
+		w.s.DecrementInventory,
+		w.s.CompleteOrder,
+	}
+	for _, activity := range activities {
+		if err := execFnAsync(ctx, activity); err != nil {
+			return err
+		}
+	}
+	return nil
 }
@@ -0,0 +1,27 @@
+package workflows
+
+import (
+	"context"
+	"github.com/Volume999/BroadleafSimulation/tracing"
+	"sync/atomic"
+)
+
+// TracedWorkflow records a span for every checkout of the workflow it decorates and tags the spans
+// of the checkout's activities with the workflow ID, if ctx carries a tracer
+type TracedWorkflow struct {
+	w         Workflow
+	id        int
+	checkouts atomic.Int64
+}
+
+func NewTracedWorkflow(workflow Workflow, id int) *TracedWorkflow {
+	return &TracedWorkflow{w: workflow, id: id}
+}
+
+func (w *TracedWorkflow) Execute(ctx context.Context) error {
+	ctx = tracing.WithCheckout(ctx, w.id, int(w.checkouts.Add(1)))
+	end := tracing.Start(ctx, tracing.CatCheckout, "Checkout")
+	err := w.w.Execute(ctx)
+	end(err)
+	return err
+}
@@ -1,5 +1,10 @@
 package workflows
 
+import "context"
+
+// Workflow executes one checkout. It returns ctx.Err() if the checkout was cancelled
+// or its deadline was exceeded before it finished. Transactional workflows also return the cause
+// of a checkout that was neither committed nor rolled back, see AsyncDBWorkflow.Execute.
 type Workflow interface {
-	Execute()
+	Execute(ctx context.Context) error
 }
@@ -0,0 +1,157 @@
+package workload
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// CacheKey is a key of a table
+type CacheKey struct {
+	Table string
+	Key   int
+}
+
+type cacheEntry struct {
+	key     CacheKey
+	expires time.Time
+}
+
+// Cache is an LRU cache of table keys with a capacity and a time to live. It holds no values, it only
+// tracks which reads would be served from a cache. It is safe for concurrent use.
+type Cache struct {
+	capacity int
+	ttl      time.Duration
+
+	mu      sync.Mutex
+	entries map[CacheKey]*list.Element
+	// lru holds the entries from the most to the least recently used
+	lru   *list.List
+	stats CacheStats
+}
+
+// NewCache creates a cache of at most capacity keys, ttl 0 means the keys do not expire
+func NewCache(capacity int, ttl time.Duration) *Cache {
+	return &Cache{
+		capacity: capacity,
+		ttl:      ttl,
+		entries:  make(map[CacheKey]*list.Element, capacity),
+		lru:      list.New(),
+	}
+}
+
+func (c *Cache) Capacity() int {
+	return c.capacity
+}
+
+func (c *Cache) TTL() time.Duration {
+	return c.ttl
+}
+
+// CacheStats count the lookups of a Cache and why keys left it
+type CacheStats struct {
+	Hits   int
+	Misses int
+	// Expired keys outlived the time to live, Evicted ones were the least recently used of a full cache,
+	// Invalidated ones were written
+	Expired     int
+	Evicted     int
+	Invalidated int
+}
+
+// HitRatio is the share of the lookups that were hits
+func (s CacheStats) HitRatio() float64 {
+	if s.Hits+s.Misses == 0 {
+		return 0
+	}
+	return float64(s.Hits) / float64(s.Hits+s.Misses)
+}
+
+func (s CacheStats) String() string {
+	return fmt.Sprintf("hits=%d misses=%d hit ratio=%.3f expired=%d evicted=%d invalidated=%d",
+		s.Hits, s.Misses, s.HitRatio(), s.Expired, s.Evicted, s.Invalidated)
+}
+
+func (c *Cache) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.stats
+}
+
+// Get looks the key up and reports whether it was a hit
+func (c *Cache) Get(key CacheKey) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	if ok && c.ttl > 0 && time.Now().After(e.Value.(*cacheEntry).expires) {
+		c.remove(e)
+		c.stats.Expired++
+		ok = false
+	}
+	if !ok {
+		c.stats.Misses++
+		return false
+	}
+	c.lru.MoveToFront(e)
+	c.stats.Hits++
+	return true
+}
+
+// Add caches the key after it was read, evicting the least recently used key of a full cache
+func (c *Cache) Add(key CacheKey) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	expires := time.Now().Add(c.ttl)
+	if e, ok := c.entries[key]; ok {
+		e.Value.(*cacheEntry).expires = expires
+		c.lru.MoveToFront(e)
+		return
+	}
+	if c.capacity <= 0 {
+		return
+	}
+	if c.lru.Len() >= c.capacity {
+		c.remove(c.lru.Back())
+		c.stats.Evicted++
+	}
+	c.entries[key] = c.lru.PushFront(&cacheEntry{key: key, expires: expires})
+}
+
+// Invalidate removes the key after it was written
+func (c *Cache) Invalidate(key CacheKey) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.entries[key]; ok {
+		c.remove(e)
+		c.stats.Invalidated++
+	}
+}
+
+// remove removes the entry, the caller holds mu
+func (c *Cache) remove(e *list.Element) {
+	delete(c.entries, e.Value.(*cacheEntry).key)
+	c.lru.Remove(e)
+}
+
+// TableAccess is the row of a table a disk access reads or writes
+type TableAccess struct {
+	Table string
+	// Row is the index of the row among the rows of the table the order accesses, e.g. of the order item
+	Row   int
+	Write bool
+}
+
+type tableAccessKey struct{}
+
+// WithTableAccess marks the disk access made with ctx as a read or a write of a row of the table,
+// so a cache in front of the disk can serve or invalidate it
+func WithTableAccess(ctx context.Context, table string, row int, write bool) context.Context {
+	return context.WithValue(ctx, tableAccessKey{}, TableAccess{Table: table, Row: row, Write: write})
+}
+
+func TableAccessFrom(ctx context.Context) (TableAccess, bool) {
+	access, ok := ctx.Value(tableAccessKey{}).(TableAccess)
+	return access, ok
+}
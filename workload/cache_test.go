@@ -0,0 +1,45 @@
+package workload
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCache(t *testing.T) {
+	c := NewCache(2, 0)
+	a, b, d := CacheKey{"Items", 1}, CacheKey{"Items", 2}, CacheKey{"StockKeepingUnits", 1}
+	if c.Get(a) {
+		t.Error("Hit in an empty cache")
+	}
+	c.Add(a)
+	c.Add(b)
+	if !c.Get(a) {
+		t.Error("Miss of a cached key")
+	}
+	// b is the least recently used key
+	c.Add(d)
+	if c.Get(b) || !c.Get(a) || !c.Get(d) {
+		t.Error("The least recently used key was not evicted")
+	}
+	c.Invalidate(a)
+	if c.Get(a) {
+		t.Error("Hit of an invalidated key")
+	}
+	want := CacheStats{Hits: 3, Misses: 3, Evicted: 1, Invalidated: 1}
+	if s := c.Stats(); s != want || s.HitRatio() != 0.5 {
+		t.Errorf("Stats %v, want %v", s, want)
+	}
+
+	c = NewCache(10, 20*time.Millisecond)
+	c.Add(a)
+	if !c.Get(a) {
+		t.Error("Miss of a fresh key")
+	}
+	time.Sleep(30 * time.Millisecond)
+	if c.Get(a) {
+		t.Error("Hit of an expired key")
+	}
+	if s := c.Stats(); s.Expired != 1 {
+		t.Errorf("Stats %v, want 1 expired key", s)
+	}
+}
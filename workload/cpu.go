@@ -0,0 +1,79 @@
+package workload
+
+import (
+	"fmt"
+	"math"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// burn runs iterations of a xorshift generator from the state x and returns the final state.
+// Every iteration depends on the previous one, so the work can be neither vectorized nor skipped.
+// The callers keep the result alive in a local sink, so the compiler cannot remove the computation,
+// and concurrent CPU work shares no memory.
+func burn(x uint64, iterations int) uint64 {
+	x |= 1
+	for range iterations {
+		x ^= x << 13
+		x ^= x >> 7
+		x ^= x << 17
+	}
+	return x
+}
+
+// CpuCalibration is the speed of the CPU work of SimulateCpuLoad on this host
+type CpuCalibration struct {
+	// IterationsPerUs is the number of work iterations per microsecond of CPU
+	IterationsPerUs float64
+	// Took is how long the calibration took
+	Took time.Duration
+}
+
+func (c CpuCalibration) String() string {
+	return fmt.Sprintf("%.1f iterations/µs (calibrated in %v)", c.IterationsPerUs, c.Took.Round(time.Millisecond))
+}
+
+const (
+	// cpuCalibrationRounds are measured, and the fastest one is used, so a round that was
+	// preempted does not slow down all CPU work
+	cpuCalibrationRounds = 5
+	// cpuCalibrationRound is the minimum duration of a round
+	cpuCalibrationRound = 10 * time.Millisecond
+)
+
+var (
+	cpuCalibrationOnce sync.Once
+	cpuCalibration     CpuCalibration
+)
+
+// CalibrateCpu measures the speed of the CPU work on this host the first time it is called,
+// and returns the result. Call it at startup, otherwise the first SimulateCpuLoad calibrates.
+func CalibrateCpu() CpuCalibration {
+	cpuCalibrationOnce.Do(func() {
+		start := time.Now()
+		var best float64
+		for range cpuCalibrationRounds {
+			for n := 1 << 12; ; n *= 2 {
+				roundStart := time.Now()
+				runtime.KeepAlive(burn(uint64(roundStart.UnixNano()), n))
+				if elapsed := time.Since(roundStart); elapsed >= cpuCalibrationRound {
+					best = max(best, float64(n)/float64(elapsed.Microseconds()))
+					break
+				}
+			}
+		}
+		cpuCalibration = CpuCalibration{IterationsPerUs: best, Took: time.Since(start)}
+	})
+	return cpuCalibration
+}
+
+// SimulateCpuLoad keeps the CPU busy for about us microseconds of CPU time, see CalibrateCpu.
+// On a loaded host the wall time is longer, the goroutine competes for the CPU with the others.
+func SimulateCpuLoad(us int) {
+	if us <= 0 {
+		return
+	}
+	sink := burn(uint64(us), int(math.Ceil(float64(us)*CalibrateCpu().IterationsPerUs)))
+	runtime.KeepAlive(sink)
+}
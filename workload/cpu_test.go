@@ -0,0 +1,31 @@
+package workload
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSimulateCpuLoad(t *testing.T) {
+	c := CalibrateCpu()
+	if c.IterationsPerUs <= 0 {
+		t.Fatalf("Calibration = %v, want a positive speed", c)
+	}
+	if again := CalibrateCpu(); again != c {
+		t.Errorf("Second calibration = %v, want %v", again, c)
+	}
+	// The fastest of several runs, so a preempted run does not fail the test
+	best := time.Duration(1<<63 - 1)
+	for range 5 {
+		start := time.Now()
+		SimulateCpuLoad(20_000)
+		best = min(best, time.Since(start))
+	}
+	if best < 10*time.Millisecond || best > 60*time.Millisecond {
+		t.Errorf("SimulateCpuLoad(20ms) took %v", best)
+	}
+	start := time.Now()
+	SimulateCpuLoad(0)
+	if elapsed := time.Since(start); elapsed > time.Millisecond {
+		t.Errorf("SimulateCpuLoad(0) took %v", elapsed)
+	}
+}
@@ -1,43 +1,94 @@
 package workload
 
 import (
+	"context"
+	"github.com/Volume999/BroadleafSimulation/tracing"
 	"sync"
+	"time"
 )
 
+// diskLogCpuUs is the CPU work of writing to the log in microseconds. Like the CPU work of the activities
+// (see the simulator package), it is the original count of 10 loop iterations divided by 10.
+const diskLogCpuUs = 1
+
 type DiskAccessSimulator interface {
-	SimulateDiskAccess()
+	SimulateDiskAccess(ctx context.Context) error
 }
 
-type UnsafeDiskAccessSimulator struct {
+// accessTime is the time every access of a disk takes, fixed or drawn from a latency distribution
+type accessTime struct {
 	accessTimeMs int
+	latency      Latency
+	streams      StreamFunc
+}
+
+// next returns the time of the access in ctx
+func (a *accessTime) next(ctx context.Context) time.Duration {
+	if a.latency != nil {
+		return drawAccess(ctx, a.streams, accessName(ctx, "disk", ""), a.latency.Sample)
+	}
+	return time.Duration(a.accessTimeMs) * time.Millisecond
+}
+
+type DiskOption func(*accessTime)
+
+// WithLatency draws the access times of a disk from the distribution instead of the fixed access time.
+// The time of an access is drawn from the stream of the access in its checkout, named after its activity
+// and the row it accesses (see WithActivity and WithTableAccess), so it does not depend on the accesses
+// of other checkouts.
+func WithLatency(latency Latency, streams StreamFunc) DiskOption {
+	return func(a *accessTime) {
+		a.latency, a.streams = latency, streams
+	}
 }
 
-func NewUnsafeDiskAccessSimulator(accessTimeMs int) *UnsafeDiskAccessSimulator {
+func newAccessTime(accessTimeMs int, options []DiskOption) accessTime {
+	a := accessTime{accessTimeMs: accessTimeMs}
+	for _, option := range options {
+		option(&a)
+	}
+	return a
+}
+
+type UnsafeDiskAccessSimulator struct {
+	accessTime
+}
+
+func NewUnsafeDiskAccessSimulator(accessTimeMs int, options ...DiskOption) *UnsafeDiskAccessSimulator {
 	return &UnsafeDiskAccessSimulator{
-		accessTimeMs: accessTimeMs,
+		accessTime: newAccessTime(accessTimeMs, options),
 	}
 }
 
-func (u *UnsafeDiskAccessSimulator) SimulateDiskAccess() {
-	SimulateSyncIoLoad(u.accessTimeMs)
+func (u *UnsafeDiskAccessSimulator) SimulateDiskAccess(ctx context.Context) error {
+	end := tracing.Start(ctx, tracing.CatDisk, "DiskAccess")
+	err := SimulateIoLoad(ctx, u.next(ctx))
+	end(err)
+	return err
 }
 
 type ThreadSafeDiskAccessSimulator struct {
-	lock         *sync.Mutex
-	accessTimeMs int
+	lock *sync.Mutex
+	accessTime
 }
 
-func NewThreadSafeDiskAccessSimulator(accessTimeMs int) *ThreadSafeDiskAccessSimulator {
+func NewThreadSafeDiskAccessSimulator(accessTimeMs int, options ...DiskOption) *ThreadSafeDiskAccessSimulator {
 	return &ThreadSafeDiskAccessSimulator{
-		lock:         &sync.Mutex{},
-		accessTimeMs: accessTimeMs,
+		lock:       &sync.Mutex{},
+		accessTime: newAccessTime(accessTimeMs, options),
 	}
 }
 
-func (t *ThreadSafeDiskAccessSimulator) SimulateDiskAccess() {
-	SimulateSyncIoLoad(t.accessTimeMs)
+func (t *ThreadSafeDiskAccessSimulator) SimulateDiskAccess(ctx context.Context) error {
+	end := tracing.Start(ctx, tracing.CatDisk, "DiskAccess")
+	if err := SimulateIoLoad(ctx, t.next(ctx)); err != nil {
+		end(err)
+		return err
+	}
 	t.lock.Lock()
 	// Writing to the log file
-	SimulateCpuLoad(10)
+	SimulateCpuLoad(diskLogCpuUs)
 	t.lock.Unlock()
+	end(nil)
+	return nil
 }
@@ -0,0 +1,354 @@
+package workload
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/Volume999/BroadleafSimulation/tracing"
+	"math/rand/v2"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrInjected is returned by an access that an injected error failed
+	ErrInjected = errors.New("injected fault")
+	// ErrInjectedTimeout is returned by an access that an injected timeout failed
+	ErrInjectedTimeout = errors.New("injected timeout")
+)
+
+// Fault kinds, see ParseFaultPlan
+const (
+	FaultError   = "error"
+	FaultSpike   = "spike"
+	FaultTimeout = "timeout"
+	FaultStall   = "stall"
+)
+
+// Faults are the probabilities (0-1) of the faults of an access, at most one fault is injected per access:
+//   - an error fails the access at once with ErrInjected
+//   - a spike delays the access by Spike
+//   - a timeout fails the access with ErrInjectedTimeout after Timeout
+//   - a stall blocks the access for Stall, then it proceeds
+type Faults struct {
+	ErrorRate   float64
+	SpikeRate   float64
+	Spike       time.Duration
+	TimeoutRate float64
+	Timeout     time.Duration
+	StallRate   float64
+	Stall       time.Duration
+}
+
+// String returns the spec of the faults, see ParseFaultPlan
+func (f Faults) String() string {
+	var parts []string
+	if f.ErrorRate > 0 {
+		parts = append(parts, fmt.Sprintf("%s:%g", FaultError, f.ErrorRate))
+	}
+	if f.SpikeRate > 0 {
+		parts = append(parts, fmt.Sprintf("%s:%g:%v", FaultSpike, f.SpikeRate, f.Spike))
+	}
+	if f.TimeoutRate > 0 {
+		parts = append(parts, fmt.Sprintf("%s:%g:%v", FaultTimeout, f.TimeoutRate, f.Timeout))
+	}
+	if f.StallRate > 0 {
+		parts = append(parts, fmt.Sprintf("%s:%g:%v", FaultStall, f.StallRate, f.Stall))
+	}
+	return strings.Join(parts, ",")
+}
+
+func (f Faults) total() float64 {
+	return f.ErrorRate + f.SpikeRate + f.TimeoutRate + f.StallRate
+}
+
+// FaultPlan are the faults of every access, Targets override them for the accesses of a table
+// or of an activity (see WithActivity)
+type FaultPlan struct {
+	Default Faults
+	Targets map[string]Faults
+}
+
+// For returns the faults of an access of the table during the activity, the faults of the table
+// take precedence over the ones of the activity
+func (p FaultPlan) For(table string, activity string) Faults {
+	if f, ok := p.Targets[table]; ok && table != "" {
+		return f
+	}
+	if f, ok := p.Targets[activity]; ok && activity != "" {
+		return f
+	}
+	return p.Default
+}
+
+func (p FaultPlan) String() string {
+	targets := make([]string, 0, len(p.Targets))
+	for target := range p.Targets {
+		targets = append(targets, target)
+	}
+	sort.Strings(targets)
+	var parts []string
+	if s := p.Default.String(); s != "" {
+		parts = append(parts, s)
+	}
+	for _, target := range targets {
+		for _, fault := range strings.Split(p.Targets[target].String(), ",") {
+			parts = append(parts, target+"="+fault)
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+// ParseFaultPlan parses a comma-separated list of faults. An entry without a target sets a default fault,
+// <target>=<fault> sets a fault of the accesses of a table or an activity, e.g.
+// "error:0.01,spike:0.05:50ms,Items=timeout:0.02:100ms,ValidatePayment=stall:0.01:1s". The faults are:
+//   - error:<rate>
+//   - spike:<rate>:<delay>
+//   - timeout:<rate>:<after>
+//   - stall:<rate>:<duration>
+//
+// A target with faults of its own does not get the default ones. The rates of a target must not add up to more than 1.
+func ParseFaultPlan(spec string) (FaultPlan, error) {
+	var p FaultPlan
+	if spec == "" {
+		return p, nil
+	}
+	for _, entry := range strings.Split(spec, ",") {
+		target, faultSpec, found := strings.Cut(strings.TrimSpace(entry), "=")
+		if !found {
+			target, faultSpec = "", target
+		}
+		f := p.Default
+		if target != "" {
+			f = p.Targets[target]
+		}
+		if err := f.parse(faultSpec); err != nil {
+			return FaultPlan{}, fmt.Errorf("invalid fault %q: %w", entry, err)
+		}
+		if f.total() > 1 {
+			return FaultPlan{}, fmt.Errorf("fault rates of %q add up to more than 1", target)
+		}
+		if target == "" {
+			p.Default = f
+			continue
+		}
+		if p.Targets == nil {
+			p.Targets = make(map[string]Faults)
+		}
+		p.Targets[target] = f
+	}
+	return p, nil
+}
+
+// parse sets the fault of the spec
+func (f *Faults) parse(spec string) error {
+	params := strings.Split(spec, ":")
+	kind := params[0]
+	withDuration := kind != FaultError
+	if kind != FaultError && kind != FaultSpike && kind != FaultTimeout && kind != FaultStall {
+		return fmt.Errorf("unknown fault %q, want error:<rate>, spike:<rate>:<delay>, timeout:<rate>:<after> or stall:<rate>:<duration>", kind)
+	}
+	want := 2
+	if withDuration {
+		want = 3
+	}
+	if len(params) != want {
+		return fmt.Errorf("want %d parameters", want-1)
+	}
+	rate, err := strconv.ParseFloat(params[1], 64)
+	if err != nil {
+		return err
+	}
+	if rate < 0 || rate > 1 {
+		return errors.New("rate must be between 0 and 1")
+	}
+	var d time.Duration
+	if withDuration {
+		if d, err = parseSample(params[2]); err != nil {
+			return err
+		}
+	}
+	switch kind {
+	case FaultError:
+		f.ErrorRate = rate
+	case FaultSpike:
+		f.SpikeRate, f.Spike = rate, d
+	case FaultTimeout:
+		f.TimeoutRate, f.Timeout = rate, d
+	case FaultStall:
+		f.StallRate, f.Stall = rate, d
+	}
+	return nil
+}
+
+type activityKey struct{}
+
+// activity is a running activity. The accesses of the activity that share a name draw their faults
+// and latencies one after the other from the stream of the name.
+type activity struct {
+	name    string
+	mu      sync.Mutex
+	streams map[string]*rand.Rand
+}
+
+// WithActivity attaches the name of the running activity to ctx, so faults can target its accesses,
+// and its accesses draw their faults and latencies from streams of the activity (see accessStream)
+func WithActivity(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, activityKey{}, &activity{name: name, streams: make(map[string]*rand.Rand)})
+}
+
+func ActivityFrom(ctx context.Context) string {
+	if a, ok := ctx.Value(activityKey{}).(*activity); ok {
+		return a.name
+	}
+	return ""
+}
+
+// accessName names the draws of kind of an access of the table (empty for a disk access) in ctx
+// after its activity and the row it accesses (see WithTableAccess)
+func accessName(ctx context.Context, kind string, table string) string {
+	name := kind + "/" + ActivityFrom(ctx) + "/" + table
+	if access, ok := TableAccessFrom(ctx); ok {
+		name += fmt.Sprintf("/%s/%d/%t", access.Table, access.Row, access.Write)
+	}
+	return name
+}
+
+// drawAccess draws with f from the named stream of the access in ctx. The accesses of an activity that share
+// a name draw one after the other from one stream, so if they run concurrently they may swap their draws.
+// An access outside of an activity draws from a new stream.
+func drawAccess[T any](ctx context.Context, streams StreamFunc, name string, f func(r *rand.Rand) T) T {
+	a, ok := ctx.Value(activityKey{}).(*activity)
+	if !ok {
+		return f(streams(ctx, name))
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	r, ok := a.streams[name]
+	if !ok {
+		r = streams(ctx, name)
+		a.streams[name] = r
+	}
+	return f(r)
+}
+
+// FaultStats count the accesses and the injected faults of a FaultInjector
+type FaultStats struct {
+	Accesses int
+	Errors   int
+	Spikes   int
+	Timeouts int
+	Stalls   int
+}
+
+func (s FaultStats) String() string {
+	return fmt.Sprintf("accesses=%d errors=%d spikes=%d timeouts=%d stalls=%d", s.Accesses, s.Errors, s.Spikes, s.Timeouts, s.Stalls)
+}
+
+// FaultInjector injects the faults of a plan into accesses. It is safe for concurrent use.
+// The fault of an access is drawn from the stream of the access in its checkout, named after the activity,
+// the table and the row of the access (see drawAccess), so a checkout gets the same faults whatever the
+// other checkouts do.
+type FaultInjector struct {
+	plan    FaultPlan
+	streams StreamFunc
+	mu      sync.Mutex
+	stats   FaultStats
+}
+
+// NewFaultInjector creates the injector, the faults are drawn from the streams of the checkouts
+func NewFaultInjector(plan FaultPlan, streams StreamFunc) *FaultInjector {
+	return &FaultInjector{plan: plan, streams: streams}
+}
+
+func (i *FaultInjector) Plan() FaultPlan {
+	return i.plan
+}
+
+func (i *FaultInjector) Stats() FaultStats {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.stats
+}
+
+// draw draws the fault of an access of the table in ctx and counts it, "" means no fault
+func (i *FaultInjector) draw(ctx context.Context, table string, f Faults) string {
+	var u float64
+	if f.total() > 0 {
+		u = drawAccess(ctx, i.streams, accessName(ctx, "faults", table), (*rand.Rand).Float64)
+	}
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.stats.Accesses++
+	if f.total() == 0 {
+		return ""
+	}
+	switch {
+	case u < f.ErrorRate:
+		i.stats.Errors++
+		return FaultError
+	case u < f.ErrorRate+f.SpikeRate:
+		i.stats.Spikes++
+		return FaultSpike
+	case u < f.ErrorRate+f.SpikeRate+f.TimeoutRate:
+		i.stats.Timeouts++
+		return FaultTimeout
+	case u < f.total():
+		i.stats.Stalls++
+		return FaultStall
+	}
+	return ""
+}
+
+// Inject injects the fault of an access of the table (empty for a disk access) during the activity in ctx,
+// before the access is made. It returns the error that fails the access, or ctx.Err() if ctx is done
+// while the fault delays it.
+func (i *FaultInjector) Inject(ctx context.Context, table string) error {
+	activity := ActivityFrom(ctx)
+	f := i.plan.For(table, activity)
+	kind := i.draw(ctx, table, f)
+	if kind == "" {
+		return nil
+	}
+	target := table
+	if target == "" {
+		target = activity
+	}
+	end := tracing.Start(ctx, tracing.CatFault, strings.TrimSpace(kind+" "+target))
+	var err error
+	switch kind {
+	case FaultError:
+		err = fmt.Errorf("%w - %s", ErrInjected, target)
+	case FaultSpike:
+		err = SimulateIoLoad(ctx, f.Spike)
+	case FaultTimeout:
+		if err = SimulateIoLoad(ctx, f.Timeout); err == nil {
+			err = fmt.Errorf("%w - %s after %v", ErrInjectedTimeout, target, f.Timeout)
+		}
+	case FaultStall:
+		err = SimulateIoLoad(ctx, f.Stall)
+	}
+	end(err)
+	return err
+}
+
+// FaultyDiskAccessSimulator injects faults into the accesses of a disk, the faults of the activity
+// in the context of the access apply
+type FaultyDiskAccessSimulator struct {
+	disk   DiskAccessSimulator
+	faults *FaultInjector
+}
+
+func NewFaultyDiskAccessSimulator(disk DiskAccessSimulator, faults *FaultInjector) *FaultyDiskAccessSimulator {
+	return &FaultyDiskAccessSimulator{disk: disk, faults: faults}
+}
+
+func (f *FaultyDiskAccessSimulator) SimulateDiskAccess(ctx context.Context) error {
+	if err := f.faults.Inject(ctx, ""); err != nil {
+		return err
+	}
+	return f.disk.SimulateDiskAccess(ctx)
+}
@@ -0,0 +1,78 @@
+package workload
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"testing"
+	"time"
+)
+
+func TestParseFaultPlan(t *testing.T) {
+	spec := "error:0.01,spike:0.05:50ms,Items=timeout:0.02:100ms,ValidatePayment=stall:0.01:1s,ValidatePayment=error:0.1"
+	p, err := ParseFaultPlan(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (Faults{ErrorRate: 0.01, SpikeRate: 0.05, Spike: 50 * time.Millisecond}); p.Default != want {
+		t.Errorf("Default faults %v, want %v", p.Default, want)
+	}
+	if want := (Faults{ErrorRate: 0.1, StallRate: 0.01, Stall: time.Second}); p.Targets["ValidatePayment"] != want {
+		t.Errorf("ValidatePayment faults %v, want %v", p.Targets["ValidatePayment"], want)
+	}
+	if f := p.For("Items", "ValidatePayment"); f != p.Targets["Items"] {
+		t.Errorf("Faults of Items in ValidatePayment %v, want the ones of the table", f)
+	}
+	if f := p.For("", "ValidatePayment"); f != p.Targets["ValidatePayment"] {
+		t.Errorf("Faults of a disk access in ValidatePayment %v, want the ones of the activity", f)
+	}
+	if f := p.For("Orders", "CompleteOrder"); f != p.Default {
+		t.Errorf("Faults of Orders in CompleteOrder %v, want the default ones", f)
+	}
+	if again, err := ParseFaultPlan(p.String()); err != nil || again.String() != p.String() {
+		t.Errorf("%s does not parse back: %v, %v", p, again, err)
+	}
+	for _, spec := range []string{"error", "error:2", "error:0.1:1ms", "spike:0.1", "timeout:0.1:-1ms", "crash:0.1",
+		"error:0.6,stall:0.6:1s", "Items=error:x"} {
+		if _, err := ParseFaultPlan(spec); err == nil {
+			t.Errorf("%q parsed", spec)
+		}
+	}
+}
+
+func TestFaultInjector(t *testing.T) {
+	r := rand.New(rand.NewPCG(1, 2))
+	// Every access gets a stream of its own
+	streams := func(context.Context, string) *rand.Rand {
+		return rand.New(rand.NewPCG(r.Uint64(), r.Uint64()))
+	}
+	ctx := context.Background()
+	inject := func(f Faults, ctx context.Context) (error, time.Duration) {
+		i := NewFaultInjector(FaultPlan{Default: f}, streams)
+		start := time.Now()
+		err := i.Inject(ctx, "Items")
+		return err, time.Since(start)
+	}
+	if err, _ := inject(Faults{ErrorRate: 1}, ctx); !errors.Is(err, ErrInjected) {
+		t.Errorf("Injected error %v, want ErrInjected", err)
+	}
+	if err, elapsed := inject(Faults{TimeoutRate: 1, Timeout: 20 * time.Millisecond}, ctx); !errors.Is(err, ErrInjectedTimeout) || elapsed < 20*time.Millisecond {
+		t.Errorf("Injected timeout %v after %v, want ErrInjectedTimeout after 20ms", err, elapsed)
+	}
+	if err, elapsed := inject(Faults{SpikeRate: 1, Spike: 20 * time.Millisecond}, ctx); err != nil || elapsed < 20*time.Millisecond {
+		t.Errorf("Injected spike %v after %v, want no error after 20ms", err, elapsed)
+	}
+	cancelled, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	if err, elapsed := inject(Faults{StallRate: 1, Stall: time.Minute}, cancelled); !errors.Is(err, context.DeadlineExceeded) || elapsed > time.Second {
+		t.Errorf("Injected stall %v after %v, want the deadline of the context after 20ms", err, elapsed)
+	}
+
+	i := NewFaultInjector(FaultPlan{Default: Faults{ErrorRate: 0.1, SpikeRate: 0.2}}, streams)
+	for range 10000 {
+		_ = i.Inject(ctx, "Items")
+	}
+	if s := i.Stats(); s.Accesses != 10000 || s.Errors < 900 || s.Errors > 1100 || s.Spikes < 1850 || s.Spikes > 2150 || s.Timeouts+s.Stalls != 0 {
+		t.Errorf("Stats %v, want about 1000 errors and 2000 spikes of 10000 accesses", s)
+	}
+}
@@ -0,0 +1,273 @@
+package workload
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Latency is a distribution of access times
+type Latency interface {
+	Sample(r *rand.Rand) time.Duration
+	// String returns the spec of the distribution, see ParseLatency
+	String() string
+}
+
+// Latency distributions, see ParseLatency
+const (
+	ConstantLatency    = "constant"
+	ExponentialLatency = "exp"
+	LogNormalLatency   = "lognormal"
+	ParetoLatency      = "pareto"
+	BimodalLatency     = "bimodal"
+	EmpiricalLatency   = "empirical"
+)
+
+// Constant is the same latency for every access
+type Constant struct {
+	D time.Duration
+}
+
+func (c Constant) Sample(*rand.Rand) time.Duration {
+	return c.D
+}
+
+func (c Constant) String() string {
+	return ConstantLatency + ":" + c.D.String()
+}
+
+// Exponential latencies have the given mean
+type Exponential struct {
+	Mean time.Duration
+}
+
+func (e Exponential) Sample(r *rand.Rand) time.Duration {
+	return time.Duration(r.ExpFloat64() * float64(e.Mean))
+}
+
+func (e Exponential) String() string {
+	return ExponentialLatency + ":" + e.Mean.String()
+}
+
+// LogNormal latencies have the given median, their logarithm has the standard deviation Sigma
+type LogNormal struct {
+	Median time.Duration
+	Sigma  float64
+}
+
+func (l LogNormal) Sample(r *rand.Rand) time.Duration {
+	return time.Duration(float64(l.Median) * math.Exp(l.Sigma*r.NormFloat64()))
+}
+
+func (l LogNormal) String() string {
+	return fmt.Sprintf("%s:%v:%g", LogNormalLatency, l.Median, l.Sigma)
+}
+
+// maxParetoScales caps Pareto latencies at this many times the scale. With a small Alpha
+// the distribution has no finite mean, and a single access could otherwise block a run for hours.
+const maxParetoScales = 1000
+
+// Pareto latencies are at least Scale, the smaller Alpha, the heavier the tail
+type Pareto struct {
+	Scale time.Duration
+	Alpha float64
+}
+
+func (p Pareto) Sample(r *rand.Rand) time.Duration {
+	// 1 - Float64() is in (0, 1]
+	scales := math.Pow(1-r.Float64(), -1/p.Alpha)
+	return time.Duration(float64(p.Scale) * min(scales, maxParetoScales))
+}
+
+func (p Pareto) String() string {
+	return fmt.Sprintf("%s:%v:%g", ParetoLatency, p.Scale, p.Alpha)
+}
+
+// Bimodal latencies are Hit, or Miss with probability MissProb (0-1), like a cache in front of a disk
+type Bimodal struct {
+	Hit      time.Duration
+	Miss     time.Duration
+	MissProb float64
+}
+
+func (b Bimodal) Sample(r *rand.Rand) time.Duration {
+	if r.Float64() < b.MissProb {
+		return b.Miss
+	}
+	return b.Hit
+}
+
+func (b Bimodal) String() string {
+	return fmt.Sprintf("%s:%v:%v:%g", BimodalLatency, b.Hit, b.Miss, b.MissProb)
+}
+
+// Empirical latencies are drawn uniformly from measured samples
+type Empirical struct {
+	Path    string
+	Samples []time.Duration
+}
+
+func (e Empirical) Sample(r *rand.Rand) time.Duration {
+	return e.Samples[r.IntN(len(e.Samples))]
+}
+
+func (e Empirical) String() string {
+	return EmpiricalLatency + ":" + e.Path
+}
+
+// LoadEmpirical reads latency samples from a file with one sample per line, a duration such as 1.5ms
+// or a number of milliseconds. Empty lines and lines starting with # are skipped.
+func LoadEmpirical(path string) (*Empirical, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	e := &Empirical{Path: path}
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		d, err := parseSample(text)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		e.Samples = append(e.Samples, d)
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(e.Samples) == 0 {
+		return nil, fmt.Errorf("%s has no latency samples", path)
+	}
+	return e, nil
+}
+
+func parseSample(text string) (time.Duration, error) {
+	d, err := time.ParseDuration(text)
+	if err != nil {
+		ms, msErr := strconv.ParseFloat(text, 64)
+		if msErr != nil {
+			return 0, err
+		}
+		d = time.Duration(ms * float64(time.Millisecond))
+	}
+	if d < 0 {
+		return 0, fmt.Errorf("negative latency %v", d)
+	}
+	return d, nil
+}
+
+// ParseLatency parses a latency distribution:
+//   - constant:<latency>
+//   - exp:<mean>
+//   - lognormal:<median>:<sigma>
+//   - pareto:<scale>:<alpha> - capped at 1000 times the scale
+//   - bimodal:<hit>:<miss>:<miss probability 0-1>
+//   - empirical:<file> - see LoadEmpirical
+//
+// Latencies are durations such as 1.5ms, or numbers of milliseconds.
+func ParseLatency(spec string) (Latency, error) {
+	kind, args, _ := strings.Cut(spec, ":")
+	if kind == EmpiricalLatency {
+		e, err := LoadEmpirical(args)
+		if err != nil {
+			return nil, err
+		}
+		return e, nil
+	}
+	params := strings.Split(args, ":")
+	var l Latency
+	var ds []time.Duration
+	var x float64
+	var err error
+	switch {
+	case kind == ConstantLatency && len(params) == 1:
+		if ds, _, err = parseParams(params, false); err == nil {
+			l = Constant{ds[0]}
+		}
+	case kind == ExponentialLatency && len(params) == 1:
+		if ds, _, err = parseParams(params, false); err == nil {
+			l = Exponential{ds[0]}
+		}
+	case kind == LogNormalLatency && len(params) == 2:
+		if ds, x, err = parseParams(params, true); err == nil && x < 0 {
+			err = errors.New("sigma must not be negative")
+		}
+		if err == nil {
+			l = LogNormal{ds[0], x}
+		}
+	case kind == ParetoLatency && len(params) == 2:
+		if ds, x, err = parseParams(params, true); err == nil && x <= 0 {
+			err = errors.New("alpha must be positive")
+		}
+		if err == nil {
+			l = Pareto{ds[0], x}
+		}
+	case kind == BimodalLatency && len(params) == 3:
+		if ds, x, err = parseParams(params, true); err == nil && (x < 0 || x > 1) {
+			err = errors.New("miss probability must be between 0 and 1")
+		}
+		if err == nil {
+			l = Bimodal{ds[0], ds[1], x}
+		}
+	default:
+		return nil, fmt.Errorf("unknown latency distribution %q, want constant:<latency>, exp:<mean>, lognormal:<median>:<sigma>, "+
+			"pareto:<scale>:<alpha>, bimodal:<hit>:<miss>:<miss probability> or empirical:<file>", spec)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid latency distribution %q: %w", spec, err)
+	}
+	return l, nil
+}
+
+// parseParams parses the parameters of a distribution: latencies, followed by a number if withNumber
+func parseParams(params []string, withNumber bool) ([]time.Duration, float64, error) {
+	var x float64
+	if withNumber {
+		var err error
+		if x, err = strconv.ParseFloat(params[len(params)-1], 64); err != nil {
+			return nil, 0, err
+		}
+		params = params[:len(params)-1]
+	}
+	ds := make([]time.Duration, len(params))
+	for i, param := range params {
+		d, err := parseSample(param)
+		if err != nil {
+			return nil, 0, err
+		}
+		ds[i] = d
+	}
+	return ds, x, nil
+}
+
+// LatencySampler draws latencies from a distribution, it is safe for concurrent use
+type LatencySampler struct {
+	latency Latency
+	mu      sync.Mutex
+	r       *rand.Rand
+}
+
+func NewLatencySampler(latency Latency, r *rand.Rand) *LatencySampler {
+	return &LatencySampler{latency: latency, r: r}
+}
+
+func (s *LatencySampler) Sample() time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.latency.Sample(s.r)
+}
+
+func (s *LatencySampler) String() string {
+	return s.latency.String()
+}
@@ -0,0 +1,115 @@
+package workload
+
+import (
+	"context"
+	"hash/fnv"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParseLatency(t *testing.T) {
+	for spec, want := range map[string]Latency{
+		"constant:10ms":         Constant{10 * time.Millisecond},
+		"exp:2.5":               Exponential{2500 * time.Microsecond},
+		"lognormal:8ms:0.5":     LogNormal{8 * time.Millisecond, 0.5},
+		"pareto:5ms:1.5":        Pareto{5 * time.Millisecond, 1.5},
+		"bimodal:1ms:50ms:0.1":  Bimodal{time.Millisecond, 50 * time.Millisecond, 0.1},
+		"bimodal:100us:20:0.25": Bimodal{100 * time.Microsecond, 20 * time.Millisecond, 0.25},
+		"constant:1.5ms":        Constant{1500 * time.Microsecond},
+	} {
+		got, err := ParseLatency(spec)
+		if err != nil {
+			t.Errorf("%s: %v", spec, err)
+			continue
+		}
+		if got != want {
+			t.Errorf("%s parsed as %v, want %v", spec, got, want)
+		}
+		if again, err := ParseLatency(got.String()); err != nil || again != got {
+			t.Errorf("%s does not parse back from %s: %v, %v", spec, got, again, err)
+		}
+	}
+	for _, spec := range []string{"", "constant", "constant:-1ms", "exp:1ms:2ms", "lognormal:1ms:-1", "pareto:1ms:0",
+		"bimodal:1ms:2ms:1.5", "normal:1ms", "empirical:/does/not/exist"} {
+		if _, err := ParseLatency(spec); err == nil {
+			t.Errorf("%q parsed", spec)
+		}
+	}
+}
+
+func TestLatencySamples(t *testing.T) {
+	r := rand.New(rand.NewPCG(1, 2))
+	const n = 100000
+	mean := func(l Latency) time.Duration {
+		var sum time.Duration
+		for range n {
+			sum += l.Sample(r)
+		}
+		return sum / n
+	}
+	if m := mean(Exponential{10 * time.Millisecond}); m < 9500*time.Microsecond || m > 10500*time.Microsecond {
+		t.Errorf("exponential mean %v, want 10ms", m)
+	}
+	if m := mean(Bimodal{time.Millisecond, 11 * time.Millisecond, 0.1}); m < 1900*time.Microsecond || m > 2100*time.Microsecond {
+		t.Errorf("bimodal mean %v, want 2ms", m)
+	}
+	// The mean of a Pareto distribution is Alpha/(Alpha-1) times the scale
+	if m := mean(Pareto{time.Millisecond, 3}); m < 1400*time.Microsecond || m > 1600*time.Microsecond {
+		t.Errorf("pareto mean %v, want 1.5ms", m)
+	}
+	for range n {
+		if d := (Pareto{time.Millisecond, 0.1}).Sample(r); d < time.Millisecond || d > maxParetoScales*time.Millisecond {
+			t.Fatalf("pareto sample %v out of [1ms, 1s]", d)
+		}
+	}
+
+	path := filepath.Join(t.TempDir(), "samples.txt")
+	if err := os.WriteFile(path, []byte("# disk latencies\n1ms\n\n2.5\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	l, err := ParseLatency("empirical:" + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := map[time.Duration]bool{}
+	for range 100 {
+		seen[l.Sample(r)] = true
+	}
+	if len(seen) != 2 || !seen[time.Millisecond] || !seen[2500*time.Microsecond] {
+		t.Errorf("empirical samples %v, want 1ms and 2.5ms", seen)
+	}
+}
+
+func TestDiskLatencyFollowsTheAccess(t *testing.T) {
+	// The stream of a draw depends on its name only, like the streams of a checkout with a fixed seed
+	streams := func(_ context.Context, name string) *rand.Rand {
+		h := fnv.New64a()
+		_, _ = h.Write([]byte(name))
+		return rand.New(rand.NewPCG(1, h.Sum64()))
+	}
+	a := newAccessTime(0, []DiskOption{WithLatency(Exponential{time.Millisecond}, streams)})
+	// times returns the times of 3 accesses of an activity to the rows of a table, in the given order
+	times := func(rows ...int) map[int][]time.Duration {
+		ctx := WithActivity(context.Background(), "ValidateAvailability")
+		res := make(map[int][]time.Duration)
+		for _, row := range rows {
+			res[row] = append(res[row], a.next(WithTableAccess(ctx, "Items", row, false)), a.next(ctx))
+		}
+		return res
+	}
+	first, second := times(0, 1, 2), times(2, 1, 0)
+	for row := range 3 {
+		if first[row][0] != second[row][0] {
+			t.Errorf("access of row %d took %v, then %v in another order", row, first[row][0], second[row][0])
+		}
+	}
+	if first[0][0] == first[1][0] {
+		t.Errorf("accesses of rows 0 and 1 both took %v, want times of their own", first[0][0])
+	}
+	if first[0][1] == first[1][1] || first[0][1] != second[2][1] {
+		t.Errorf("accesses without a row took %v and %v, want the next times of the activity's stream in order", first[0][1], first[1][1])
+	}
+}
@@ -1,14 +1,28 @@
 package workload
 
 import (
+	"context"
+	"math/rand/v2"
 	"time"
 )
 
-func SimulateCpuLoad(cpuLoadCycles int) {
-	for range cpuLoadCycles {
-	}
+// StreamFunc returns the random stream of a named draw of the checkout in ctx, e.g. simulator.ActivityRand.
+// Draws from the streams of the checkout do not depend on how the checkouts of a run interleave.
+type StreamFunc func(ctx context.Context, name string) *rand.Rand
+
+// SimulateSyncIoLoad blocks for timeMs milliseconds, or until ctx is done
+func SimulateSyncIoLoad(ctx context.Context, timeMs int) error {
+	return SimulateIoLoad(ctx, time.Duration(timeMs)*time.Millisecond)
 }
 
-func SimulateSyncIoLoad(timeMs int) {
-	time.Sleep(time.Duration(timeMs) * time.Millisecond)
+// SimulateIoLoad blocks for d, or until ctx is done
+func SimulateIoLoad(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
 }
@@ -0,0 +1,142 @@
+package workload
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/Volume999/BroadleafSimulation/metrics"
+	"github.com/Volume999/BroadleafSimulation/tracing"
+	"sync"
+	"time"
+)
+
+// ErrDiskQueueFull is returned by a disk access that finds the queue of a QueueingDiskAccessSimulator full
+var ErrDiskQueueFull = errors.New("disk queue full")
+
+// QueueingDiskAccessSimulator models a device that serves at most channels accesses at once, each for
+// the service time, fixed or drawn from a distribution (see WithLatency). Further accesses wait in a FIFO
+// queue of at most maxQueue accesses (0 means no limit), accesses that find the queue full are rejected
+// with ErrDiskQueueFull.
+type QueueingDiskAccessSimulator struct {
+	serviceTime accessTime
+	maxQueue    int
+	slots       chan struct{}
+	waits       *metrics.Histogram
+
+	mu       sync.Mutex
+	queued   int
+	peak     int
+	accesses int
+	rejected int
+	// queueArea is the integral of the queue length over time since start, for the time-weighted mean
+	queueArea  time.Duration
+	lastChange time.Time
+	start      time.Time
+}
+
+func NewQueueingDiskAccessSimulator(channels int, serviceTimeMs int, maxQueue int, options ...DiskOption) *QueueingDiskAccessSimulator {
+	now := time.Now()
+	return &QueueingDiskAccessSimulator{
+		serviceTime: newAccessTime(serviceTimeMs, options),
+		maxQueue:    maxQueue,
+		slots:       make(chan struct{}, channels),
+		waits:       metrics.NewHistogram(),
+		lastChange:  now,
+		start:       now,
+	}
+}
+
+// DiskStats are the statistics of a QueueingDiskAccessSimulator
+type DiskStats struct {
+	// Accesses is the number of accesses that were admitted to the device, Rejected found the queue full
+	Accesses int
+	Rejected int
+	// Wait is the distribution of the time admitted accesses waited in the queue before service
+	Wait metrics.Summary
+	// MeanQueue is the time-weighted mean queue length, PeakQueue the longest queue
+	MeanQueue float64
+	PeakQueue int
+}
+
+func (s DiskStats) String() string {
+	return fmt.Sprintf("accesses=%d rejected=%d queue mean=%.2f peak=%d wait %v",
+		s.Accesses, s.Rejected, s.MeanQueue, s.PeakQueue, s.Wait)
+}
+
+// Stats returns the statistics of the accesses so far
+func (q *QueueingDiskAccessSimulator) Stats() DiskStats {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	now := time.Now()
+	area := q.queueArea + time.Duration(q.queued)*now.Sub(q.lastChange)
+	s := DiskStats{Accesses: q.accesses, Rejected: q.rejected, Wait: q.waits.Summary(), PeakQueue: q.peak}
+	if elapsed := now.Sub(q.start); elapsed > 0 {
+		s.MeanQueue = float64(area) / float64(elapsed)
+	}
+	return s
+}
+
+// changeQueue adds delta to the queue length, the caller holds mu
+func (q *QueueingDiskAccessSimulator) changeQueue(delta int) {
+	now := time.Now()
+	q.queueArea += time.Duration(q.queued) * now.Sub(q.lastChange)
+	q.lastChange = now
+	q.queued += delta
+	q.peak = max(q.peak, q.queued)
+}
+
+// enqueue admits the access to the queue, or rejects it if the queue is full
+func (q *QueueingDiskAccessSimulator) enqueue() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.maxQueue > 0 && q.queued >= q.maxQueue {
+		q.rejected++
+		return false
+	}
+	q.accesses++
+	q.changeQueue(1)
+	return true
+}
+
+func (q *QueueingDiskAccessSimulator) dequeue() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.changeQueue(-1)
+}
+
+// acquire waits for a free service channel. An access is queued only if all channels are busy.
+func (q *QueueingDiskAccessSimulator) acquire(ctx context.Context) error {
+	select {
+	case q.slots <- struct{}{}:
+		q.mu.Lock()
+		q.accesses++
+		q.mu.Unlock()
+		q.waits.Record(0)
+		return nil
+	default:
+	}
+	if !q.enqueue() {
+		return ErrDiskQueueFull
+	}
+	defer q.dequeue()
+	arrived := time.Now()
+	select {
+	case q.slots <- struct{}{}:
+		q.waits.Record(time.Since(arrived))
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (q *QueueingDiskAccessSimulator) SimulateDiskAccess(ctx context.Context) error {
+	end := tracing.Start(ctx, tracing.CatDisk, "DiskAccess")
+	if err := q.acquire(ctx); err != nil {
+		end(err)
+		return err
+	}
+	err := SimulateIoLoad(ctx, q.serviceTime.next(ctx))
+	<-q.slots
+	end(err)
+	return err
+}
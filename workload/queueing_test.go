@@ -0,0 +1,60 @@
+package workload
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// accessConcurrently runs n disk accesses at once and returns their errors
+func accessConcurrently(disk DiskAccessSimulator, n int) []error {
+	errs := make([]error, n)
+	wg := sync.WaitGroup{}
+	for i := range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = disk.SimulateDiskAccess(context.Background())
+		}()
+	}
+	wg.Wait()
+	return errs
+}
+
+func TestQueueingDiskServesChannelsAtOnce(t *testing.T) {
+	disk := NewQueueingDiskAccessSimulator(2, 20, 0)
+	start := time.Now()
+	for _, err := range accessConcurrently(disk, 6) {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 6 accesses on 2 channels take 3 service times
+	if elapsed := time.Since(start); elapsed < 60*time.Millisecond {
+		t.Errorf("6 accesses on 2 channels took %v", elapsed)
+	}
+	stats := disk.Stats()
+	if stats.Accesses != 6 || stats.Rejected != 0 || stats.PeakQueue != 4 {
+		t.Errorf("stats %v, want 6 accesses and a peak queue of 4", stats)
+	}
+	if stats.Wait.Max < 35*time.Millisecond || stats.MeanQueue <= 0 {
+		t.Errorf("stats %v, want the last accesses to wait for 2 service times", stats)
+	}
+}
+
+func TestQueueingDiskRejectsWhenQueueFull(t *testing.T) {
+	disk := NewQueueingDiskAccessSimulator(1, 20, 1)
+	rejected := 0
+	for _, err := range accessConcurrently(disk, 3) {
+		if errors.Is(err, ErrDiskQueueFull) {
+			rejected++
+		} else if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if stats := disk.Stats(); rejected != 1 || stats.Rejected != 1 || stats.Accesses != 2 {
+		t.Errorf("%d accesses rejected, stats %v, want one served, one queued and one rejected", rejected, stats)
+	}
+}
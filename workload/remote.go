@@ -0,0 +1,198 @@
+package workload
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/Volume999/BroadleafSimulation/metrics"
+	"github.com/Volume999/BroadleafSimulation/tracing"
+	"math/rand/v2"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrRemoteCall is returned by a remote call that fails, see RemoteConfig.ErrorRate
+	ErrRemoteCall = errors.New("remote call failed")
+	// ErrRemoteTimeout is returned by a remote call that takes longer than the timeout of its service
+	ErrRemoteTimeout = errors.New("remote call timed out")
+)
+
+// RemoteConfig configures a remote service, e.g. a payment gateway or a tax provider
+type RemoteConfig struct {
+	// Latency is the distribution of the response times of the service
+	Latency Latency
+	// Timeout is how long a caller waits for the service, including the wait for a connection, 0 means no timeout
+	Timeout time.Duration
+	// ErrorRate is the probability (0-1) that a call fails after its response time
+	ErrorRate float64
+	// Concurrency limits the calls in flight, further calls wait for a connection, 0 means no limit
+	Concurrency int
+}
+
+// String returns the spec of the configuration, see ParseRemoteConfig
+func (c RemoteConfig) String() string {
+	var b strings.Builder
+	b.WriteString(c.Latency.String())
+	if c.Timeout > 0 {
+		fmt.Fprintf(&b, ",timeout=%v", c.Timeout)
+	}
+	if c.ErrorRate > 0 {
+		fmt.Fprintf(&b, ",errors=%g", c.ErrorRate)
+	}
+	if c.Concurrency > 0 {
+		fmt.Fprintf(&b, ",concurrency=%d", c.Concurrency)
+	}
+	return b.String()
+}
+
+// ParseRemoteConfig parses the configuration of a remote service: a latency distribution (see ParseLatency),
+// followed by optional comma-separated settings:
+//   - timeout=<duration>
+//   - errors=<error rate 0-1>
+//   - concurrency=<calls in flight>
+//
+// e.g. lognormal:80ms:0.5,timeout=500ms,errors=0.01,concurrency=20
+func ParseRemoteConfig(spec string) (RemoteConfig, error) {
+	parts := strings.Split(spec, ",")
+	latency, err := ParseLatency(parts[0])
+	if err != nil {
+		return RemoteConfig{}, err
+	}
+	c := RemoteConfig{Latency: latency}
+	for _, part := range parts[1:] {
+		key, value, _ := strings.Cut(part, "=")
+		switch key {
+		case "timeout":
+			if c.Timeout, err = parseSample(value); err == nil && c.Timeout == 0 {
+				err = errors.New("timeout must be positive")
+			}
+		case "errors":
+			if c.ErrorRate, err = strconv.ParseFloat(value, 64); err == nil && (c.ErrorRate < 0 || c.ErrorRate > 1) {
+				err = errors.New("error rate must be between 0 and 1")
+			}
+		case "concurrency":
+			if c.Concurrency, err = strconv.Atoi(value); err == nil && c.Concurrency < 1 {
+				err = errors.New("concurrency must be positive")
+			}
+		default:
+			err = fmt.Errorf("unknown setting %q, want timeout, errors or concurrency", key)
+		}
+		if err != nil {
+			return RemoteConfig{}, fmt.Errorf("invalid remote service %q: %w", spec, err)
+		}
+	}
+	return c, nil
+}
+
+// RemoteService simulates the calls to a remote service. It is safe for concurrent use.
+type RemoteService struct {
+	name   string
+	config RemoteConfig
+	slots  chan struct{}
+	// latencies are the response times of the calls, including the ones the callers gave up on
+	latencies *metrics.Histogram
+
+	mu       sync.Mutex
+	calls    int
+	failed   int
+	timeouts int
+}
+
+// NewRemoteService creates the service, the callers draw the response times and errors of their calls (see Call)
+func NewRemoteService(name string, config RemoteConfig) *RemoteService {
+	s := &RemoteService{name: name, config: config, latencies: metrics.NewHistogram()}
+	if config.Concurrency > 0 {
+		s.slots = make(chan struct{}, config.Concurrency)
+	}
+	return s
+}
+
+func (s *RemoteService) Name() string {
+	return s.name
+}
+
+func (s *RemoteService) Config() RemoteConfig {
+	return s.config
+}
+
+// RemoteStats are the statistics of the calls to a RemoteService
+type RemoteStats struct {
+	Calls    int
+	Failed   int
+	Timeouts int
+	// Latency is the distribution of the response times of the service
+	Latency metrics.Summary
+}
+
+func (s RemoteStats) String() string {
+	return fmt.Sprintf("calls=%d failed=%d timeouts=%d latency %v", s.Calls, s.Failed, s.Timeouts, s.Latency)
+}
+
+func (s *RemoteService) Stats() RemoteStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return RemoteStats{Calls: s.calls, Failed: s.failed, Timeouts: s.timeouts, Latency: s.latencies.Summary()}
+}
+
+// draw draws the response time and the outcome of a call from r
+func (s *RemoteService) draw(r *rand.Rand) (time.Duration, bool) {
+	latency := s.config.Latency.Sample(r)
+	fails := s.config.ErrorRate > 0 && r.Float64() < s.config.ErrorRate
+	return latency, fails
+}
+
+func (s *RemoteService) count(counter *int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	*counter++
+}
+
+// Call waits for a connection of the service and for its response. It fails with ErrRemoteTimeout
+// once the timeout of the service passed, with ErrRemoteCall at the service's error rate,
+// or with ctx.Err() if ctx is done first. The response time and the outcome of the call are drawn from r,
+// the stream of the call in its checkout, so they do not depend on the calls of other checkouts.
+func (s *RemoteService) Call(ctx context.Context, r *rand.Rand) error {
+	end := tracing.Start(ctx, tracing.CatRemote, s.name)
+	err := s.call(ctx, r)
+	end(err)
+	return err
+}
+
+func (s *RemoteService) call(ctx context.Context, r *rand.Rand) error {
+	s.count(&s.calls)
+	callCtx := ctx
+	if s.config.Timeout > 0 {
+		var cancel context.CancelFunc
+		callCtx, cancel = context.WithTimeout(ctx, s.config.Timeout)
+		defer cancel()
+	}
+	// timedOut tells a timeout of the call from the cancellation of the caller
+	timedOut := func(err error) error {
+		if ctx.Err() == nil && errors.Is(err, context.DeadlineExceeded) {
+			s.count(&s.timeouts)
+			return fmt.Errorf("%w - %s after %v", ErrRemoteTimeout, s.name, s.config.Timeout)
+		}
+		return err
+	}
+	if s.slots != nil {
+		select {
+		case s.slots <- struct{}{}:
+			defer func() { <-s.slots }()
+		case <-callCtx.Done():
+			return timedOut(callCtx.Err())
+		}
+	}
+	latency, fails := s.draw(r)
+	s.latencies.Record(latency)
+	if err := SimulateIoLoad(callCtx, latency); err != nil {
+		return timedOut(err)
+	}
+	if fails {
+		s.count(&s.failed)
+		return fmt.Errorf("%w - %s", ErrRemoteCall, s.name)
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package workload
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParseRemoteConfig(t *testing.T) {
+	for spec, want := range map[string]RemoteConfig{
+		"constant:80ms": {Latency: Constant{80 * time.Millisecond}},
+		"lognormal:80ms:0.5,timeout=500ms,errors=0.01,concurrency=20": {
+			Latency: LogNormal{80 * time.Millisecond, 0.5}, Timeout: 500 * time.Millisecond, ErrorRate: 0.01, Concurrency: 20},
+		"exp:30,concurrency=4": {Latency: Exponential{30 * time.Millisecond}, Concurrency: 4},
+	} {
+		got, err := ParseRemoteConfig(spec)
+		if err != nil {
+			t.Errorf("%s: %v", spec, err)
+			continue
+		}
+		if got != want {
+			t.Errorf("%s parsed as %v, want %v", spec, got, want)
+		}
+		if again, err := ParseRemoteConfig(got.String()); err != nil || again != got {
+			t.Errorf("%s does not parse back from %s: %v, %v", spec, got, again, err)
+		}
+	}
+	for _, spec := range []string{"", "timeout=1s", "constant:1ms,timeout=0", "constant:1ms,errors=2", "constant:1ms,concurrency=0",
+		"constant:1ms,retries=3", "constant:1ms,timeout"} {
+		if _, err := ParseRemoteConfig(spec); err == nil {
+			t.Errorf("%q parsed", spec)
+		}
+	}
+}
+
+func TestRemoteService(t *testing.T) {
+	r := rand.New(rand.NewPCG(1, 2))
+	ctx := context.Background()
+
+	slow := NewRemoteService("slow", RemoteConfig{Latency: Constant{time.Second}, Timeout: 20 * time.Millisecond})
+	start := time.Now()
+	if err := slow.Call(ctx, r); !errors.Is(err, ErrRemoteTimeout) {
+		t.Errorf("Call of a slow service = %v, want ErrRemoteTimeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > 200*time.Millisecond {
+		t.Errorf("Call timed out after %v, want 20ms", elapsed)
+	}
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if err := slow.Call(cancelled, r); !errors.Is(err, context.Canceled) {
+		t.Errorf("Call with a cancelled context = %v, want context.Canceled", err)
+	}
+	if s := slow.Stats(); s.Calls != 2 || s.Timeouts != 1 {
+		t.Errorf("Stats = %v, want 2 calls and 1 timeout", s)
+	}
+
+	failing := NewRemoteService("failing", RemoteConfig{Latency: Constant{0}, ErrorRate: 0.25})
+	failed := 0
+	for range 4000 {
+		if err := failing.Call(ctx, r); errors.Is(err, ErrRemoteCall) {
+			failed++
+		}
+	}
+	if failed < 900 || failed > 1100 || failing.Stats().Failed != failed {
+		t.Errorf("%d of 4000 calls failed (stats %v), want about 1000", failed, failing.Stats())
+	}
+
+	// 8 calls of 20ms with 2 connections take 4 rounds
+	limited := NewRemoteService("limited", RemoteConfig{Latency: Constant{20 * time.Millisecond}, Concurrency: 2})
+	start = time.Now()
+	wg := sync.WaitGroup{}
+	for i := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := limited.Call(ctx, rand.New(rand.NewPCG(1, uint64(i)))); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if elapsed := time.Since(start); elapsed < 80*time.Millisecond || elapsed > 400*time.Millisecond {
+		t.Errorf("8 calls with 2 connections took %v, want 80ms", elapsed)
+	}
+}